@@ -23,8 +23,11 @@ package cmd
 import (
 	"fmt"
 	"os"
+	"strings"
 
 	log "github.com/Sirupsen/logrus"
+	"github.com/sabakaio/k8s-updater/pkg/registry"
+	"github.com/sabakaio/k8s-updater/pkg/updater"
 	"github.com/sabakaio/k8s-updater/pkg/util"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
@@ -44,7 +47,7 @@ var RootCmd = &cobra.Command{
 	// Uncomment the following line if your bare application
 	// has an action associated with it:
 	Run: func(cmd *cobra.Command, args []string) {
-		update()
+		os.Exit(update())
 	},
 }
 
@@ -53,22 +56,64 @@ var RootCmd = &cobra.Command{
 func Execute() {
 	if err := RootCmd.Execute(); err != nil {
 		fmt.Println(err)
-		os.Exit(-1)
+		os.Exit(exitFatal)
 	}
 }
 
 func init() {
-	cobra.OnInitialize(initConfig, initLogging, initClient)
+	cobra.OnInitialize(initConfig, initLogging, initClient, initRegistry, initUpdater)
 
 	RootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (default is $HOME/.k8s-updater.yaml)")
 	RootCmd.PersistentFlags().StringP("loglevel", "l", log.DebugLevel.String(), "Log level")
 	RootCmd.PersistentFlags().StringP("host", "H", "", "Kubernetes host to connect to")
 	RootCmd.PersistentFlags().StringP("namespace", "n", api.NamespaceDefault, "Kubernetes namespace")
 	RootCmd.PersistentFlags().Bool("dry-run", false, "Get versions but do not update")
+	RootCmd.PersistentFlags().Int("tags-page-size", registry.DefaultPageSize, "Number of tags to request per page of a tag list")
+	RootCmd.PersistentFlags().Int("tags-max-pages", registry.DefaultMaxPages, "Maximum number of tag list pages to fetch per repository (0 for unlimited)")
+	RootCmd.PersistentFlags().Duration("cache-ttl", 0, "Lifetime of cached tag lists, digests and pull secrets (0 to keep them for the run)")
+	RootCmd.PersistentFlags().Bool("cache-revalidate", false, "Revalidate expired tag lists with If-None-Match requests")
+	RootCmd.PersistentFlags().Int("registry-retries", registry.DefaultMaxRetries, "Number of retries of a failed registry request")
+	RootCmd.PersistentFlags().Int("registry-request-budget", registry.DefaultRequestBudget, "Maximum number of requests to one registry (0 for unlimited)")
+	RootCmd.PersistentFlags().Int("registry-breaker-threshold", registry.DefaultBreakerThreshold, "Number of failed requests in a row to consider a registry unavailable")
+	RootCmd.PersistentFlags().String("tag-source", registry.DefaultSource, "Default tag source: registry, catalog or oci")
+	RootCmd.PersistentFlags().String("catalog-file", "", "Catalog file with approved image versions")
+	RootCmd.PersistentFlags().String("catalog-configmap", "", "ConfigMap with approved image versions in `catalog.yaml` key, as <namespace>/<name>")
+	RootCmd.PersistentFlags().String("oci-layout-dir", "", "Directory with OCI image layouts of repositories")
+	RootCmd.PersistentFlags().String("docker-config", "", "Docker config.json with registry credentials (default is $DOCKER_CONFIG/config.json or $HOME/.docker/config.json)")
+	RootCmd.PersistentFlags().String("fallback-secret", "", "Image pull secret for registries without other credentials, as <namespace>/<name>")
+	RootCmd.PersistentFlags().String("version-scheme", registry.DefaultScheme, "Default version scheme of image tags: semver, calver, integer or timestamp")
+	RootCmd.PersistentFlags().String("channel", registry.DefaultChannel, "Default release channel: stable, rc, beta or alpha")
+	RootCmd.PersistentFlags().Bool("prefer-final", registry.DefaultPreferFinal, "Update containers on prerelease channels to any final release newer than the current version before a newer prerelease, e.g. 1.9.0 to 1.9.1 before 2.0.0-rc.1")
+	RootCmd.PersistentFlags().Bool("ignore-build-metadata", false, "Do not update to versions which differ by build metadata only")
+	RootCmd.PersistentFlags().String("update-policy", registry.DefaultPolicy, "Default update policy relative to the current version: patch, minor, major or none")
+	RootCmd.PersistentFlags().String("kinds", strings.Join(updater.DefaultKinds, ","), "Comma separated workload kinds to update: deployment, daemonset, replicaset, replicationcontroller, job, petset")
+	RootCmd.PersistentFlags().Int("update-retries", updater.DefaultUpdateRetries, "Number of retries of a workload update failed with a conflict")
+	RootCmd.PersistentFlags().Bool("fail-on-skip", false, "Exit with a failure code if some containers are skipped")
 	viper.BindPFlag("loglevel", RootCmd.PersistentFlags().Lookup("loglevel"))
 	viper.BindPFlag("host", RootCmd.PersistentFlags().Lookup("host"))
 	viper.BindPFlag("namespace", RootCmd.PersistentFlags().Lookup("namespace"))
 	viper.BindPFlag("dryrun", RootCmd.PersistentFlags().Lookup("dry-run"))
+	viper.BindPFlag("tags_page_size", RootCmd.PersistentFlags().Lookup("tags-page-size"))
+	viper.BindPFlag("tags_max_pages", RootCmd.PersistentFlags().Lookup("tags-max-pages"))
+	viper.BindPFlag("cache_ttl", RootCmd.PersistentFlags().Lookup("cache-ttl"))
+	viper.BindPFlag("cache_revalidate", RootCmd.PersistentFlags().Lookup("cache-revalidate"))
+	viper.BindPFlag("registry_retries", RootCmd.PersistentFlags().Lookup("registry-retries"))
+	viper.BindPFlag("registry_request_budget", RootCmd.PersistentFlags().Lookup("registry-request-budget"))
+	viper.BindPFlag("registry_breaker_threshold", RootCmd.PersistentFlags().Lookup("registry-breaker-threshold"))
+	viper.BindPFlag("tag_source", RootCmd.PersistentFlags().Lookup("tag-source"))
+	viper.BindPFlag("catalog_file", RootCmd.PersistentFlags().Lookup("catalog-file"))
+	viper.BindPFlag("catalog_configmap", RootCmd.PersistentFlags().Lookup("catalog-configmap"))
+	viper.BindPFlag("oci_layout_dir", RootCmd.PersistentFlags().Lookup("oci-layout-dir"))
+	viper.BindPFlag("docker_config", RootCmd.PersistentFlags().Lookup("docker-config"))
+	viper.BindPFlag("fallback_secret", RootCmd.PersistentFlags().Lookup("fallback-secret"))
+	viper.BindPFlag("version_scheme", RootCmd.PersistentFlags().Lookup("version-scheme"))
+	viper.BindPFlag("channel", RootCmd.PersistentFlags().Lookup("channel"))
+	viper.BindPFlag("prefer_final", RootCmd.PersistentFlags().Lookup("prefer-final"))
+	viper.BindPFlag("ignore_build_metadata", RootCmd.PersistentFlags().Lookup("ignore-build-metadata"))
+	viper.BindPFlag("update_policy", RootCmd.PersistentFlags().Lookup("update-policy"))
+	viper.BindPFlag("kinds", RootCmd.PersistentFlags().Lookup("kinds"))
+	viper.BindPFlag("update_retries", RootCmd.PersistentFlags().Lookup("update-retries"))
+	viper.BindPFlag("fail_on_skip", RootCmd.PersistentFlags().Lookup("fail-on-skip"))
 }
 
 // initConfig reads in config file and ENV variables if set.
@@ -91,7 +136,7 @@ func initConfig() {
 func initLogging() {
 	level, err := log.ParseLevel(viper.GetString("loglevel"))
 	if err != nil {
-		log.Fatalln(err)
+		fatal(err)
 	}
 	log.SetLevel(level)
 	log.Debugln("Loglevel set to", log.GetLevel().String())
@@ -102,6 +147,116 @@ func initClient() {
 	var err error
 	k, err = util.CreateClient(viper.GetString("host"))
 	if err != nil {
-		log.Fatalln("Can't connect to Kubernetes API:", err)
+		fatal("Can't connect to Kubernetes API:", err)
 	}
 }
+
+// Configure registry clients
+func initRegistry() {
+	registry.DefaultPageSize = viper.GetInt("tags_page_size")
+	registry.DefaultMaxPages = viper.GetInt("tags_max_pages")
+	registry.DefaultMaxRetries = viper.GetInt("registry_retries")
+	registry.DefaultRequestBudget = viper.GetInt("registry_request_budget")
+	registry.DefaultBreakerThreshold = viper.GetInt("registry_breaker_threshold")
+	var configs []*registry.Config
+	if err := viper.UnmarshalKey("registries", &configs); err != nil {
+		fatal("Invalid registries config:", err)
+	}
+	if err := registry.SetConfigs(configs); err != nil {
+		fatal(err)
+	}
+	registry.DefaultCache = registry.NewCache(viper.GetDuration("cache_ttl"), viper.GetBool("cache_revalidate"))
+
+	if _, err := registry.GetScheme(viper.GetString("version_scheme")); err != nil {
+		fatal(err)
+	}
+	registry.DefaultScheme = viper.GetString("version_scheme")
+	if viper.GetBool("ignore_build_metadata") {
+		registry.RegisterScheme(registry.SchemeSemver, registry.SemverScheme{IgnoreBuild: true})
+	}
+	if _, err := registry.ChannelRange(viper.GetString("channel")); err != nil {
+		fatal(err)
+	}
+	registry.DefaultChannel = viper.GetString("channel")
+	registry.DefaultPreferFinal = viper.GetBool("prefer_final")
+	if err := registry.ValidatePolicy(viper.GetString("update_policy")); err != nil {
+		fatal(err)
+	}
+	registry.DefaultPolicy = viper.GetString("update_policy")
+
+	initCredentials()
+
+	initTagSources()
+}
+
+// Configure workloads to update
+func initUpdater() {
+	var kinds []string
+	for _, kind := range strings.Split(viper.GetString("kinds"), ",") {
+		if kind = strings.TrimSpace(kind); kind != "" {
+			kinds = append(kinds, kind)
+		}
+	}
+	if err := updater.ValidateKinds(kinds); err != nil {
+		fatal(err)
+	}
+	updater.DefaultKinds = kinds
+	updater.DefaultUpdateRetries = viper.GetInt("update_retries")
+}
+
+// Credentials of docker config, credential helpers and the fallback secret are used for registries without pull secrets
+func initCredentials() {
+	path := viper.GetString("docker_config")
+	required := path != ""
+	if !required {
+		path = registry.DefaultDockerConfigPath()
+	}
+	providers, err := registry.LoadDockerConfig(path)
+	if !required && os.IsNotExist(err) {
+		providers, err = registry.ProviderChain{&registry.CredentialHelpers{}}, nil
+	}
+	if err != nil {
+		fatal("Can't load docker config:", err)
+	}
+
+	if name := viper.GetString("fallback_secret"); name != "" {
+		parts := strings.SplitN(name, "/", 2)
+		if len(parts) != 2 {
+			fatal("Invalid fallback secret name, expect <namespace>/<name>:", name)
+		}
+		providers = append(providers, &registry.SecretProvider{Client: k, Namespace: parts[0], Name: parts[1]})
+	}
+	registry.DefaultProviders = providers
+}
+
+// Register tag sources configured for air-gapped clusters
+func initTagSources() {
+	if path := viper.GetString("catalog_file"); path != "" {
+		catalog, err := registry.LoadCatalog(path)
+		if err != nil {
+			fatal("Can't load catalog:", err)
+		}
+		registry.RegisterSource(registry.SourceCatalog, catalog)
+	} else if name := viper.GetString("catalog_configmap"); name != "" {
+		parts := strings.SplitN(name, "/", 2)
+		if len(parts) != 2 {
+			fatal("Invalid catalog ConfigMap name, expect <namespace>/<name>:", name)
+		}
+		configMap, err := k.ConfigMaps(parts[0]).Get(parts[1])
+		if err != nil {
+			fatal("Can't get catalog ConfigMap:", err)
+		}
+		catalog, err := registry.ParseCatalog([]byte(configMap.Data["catalog.yaml"]))
+		if err != nil {
+			fatal("Can't load catalog:", err)
+		}
+		registry.RegisterSource(registry.SourceCatalog, catalog)
+	}
+	if dir := viper.GetString("oci_layout_dir"); dir != "" {
+		registry.RegisterSource(registry.SourceOCILayout, &registry.OCILayout{Dir: dir})
+	}
+	if _, err := registry.GetSource(viper.GetString("tag_source")); err != nil {
+		fatal(err)
+	}
+	registry.DefaultSource = viper.GetString("tag_source")
+}
@@ -1,34 +1,126 @@
 package cmd
 
 import (
+	"fmt"
 	log "github.com/Sirupsen/logrus"
+	"github.com/sabakaio/k8s-updater/pkg/registry"
 	"github.com/sabakaio/k8s-updater/pkg/updater"
 	"github.com/spf13/viper"
+	"os"
 )
 
-func update() {
+// Exit codes of the updater
+const (
+	exitOK     = 0
+	exitFailed = 1
+	exitFatal  = 2
+)
+
+// fatal logs the error and exits with `exitFatal` code
+func fatal(args ...interface{}) {
+	log.Errorln(args...)
+	os.Exit(exitFatal)
+}
+
+// update checks containers, updates workloads and returns the exit code
+func update() int {
 	list, err := updater.NewList(k, viper.GetString("namespace"))
 	if err != nil {
-		log.Fatalln("Can't get deployments", err)
+		fatal("Can't get workloads", err)
 	}
-	if len(list.Items) == 0 {
-		log.Warningln("No autoupdate deployments found")
+	if len(list.Items) == 0 && len(list.Invalid) == 0 && len(list.Failed) == 0 {
+		log.Warningln("No autoupdate workloads found")
 	}
 	dryRun := viper.GetBool("dryrun")
+	report := new(updater.Report)
+	for _, invalid := range list.Invalid {
+		report.Add(&updater.Result{
+			Workload: updater.WorkloadName(invalid.Workload),
+			Status:   updater.StatusFailed,
+			Reason:   invalid.Error(),
+		})
+		if dryRun {
+			continue
+		}
+		if err := invalid.RecordEvent(k); err != nil {
+			log.Errorf("workload=%s could not record event: %s", updater.WorkloadName(invalid.Workload), err)
+		}
+	}
+	for _, result := range list.Failed {
+		report.Add(result)
+	}
+
+	// Collect new versions to update each workload with a single write
+	updates := new(updater.UpdateList)
 	for _, c := range list.Items {
-		newVersion, err := c.GetAutoupdateVersion()
-		if err != nil {
-			log.Errorln(err)
+		newVersion, err := c.GetAvailableVersion()
+		if registry.IsUnavailable(err) {
+			log.Warnf("workload=%s container=%s skipped: %s", c.GetWorkloadName(), c.GetName(), err)
+			report.Add(c.Result(updater.StatusSkipped, err.Error()))
+			continue
+		} else if err != nil {
+			log.Errorf("workload=%s container=%s %s", c.GetWorkloadName(), c.GetName(), err)
+			report.Add(c.Result(updater.StatusFailed, err.Error()))
+			continue
+		}
+		if newVersion == nil {
+			log.Debugf("workload=%s container=%s nothing to update", c.GetWorkloadName(), c.GetName())
+			report.Add(c.Result(updater.StatusUpToDate, ""))
+			continue
+		}
+		if c.ReportOnly() {
+			log.Infof("workload=%s container=%s version %s is available, not updated by policy '%s'",
+				c.GetWorkloadName(), c.GetName(), newVersion.String(), c.GetUpdatePolicy())
+			result := c.Result(updater.StatusAvailable, fmt.Sprintf("update policy '%s'", c.GetUpdatePolicy()))
+			result.Version = newVersion.String()
+			report.Add(result)
+			continue
 		}
-		if newVersion != nil {
-			if dryRun {
-				log.Infof("deployment=%s container=%s can be updated up to version %s. DRYRUN", c.GetDeploymentName(), c.GetName(), newVersion.String())
-				continue
+		if dryRun {
+			log.Infof("workload=%s container=%s can be updated up to version %s. DRYRUN", c.GetWorkloadName(), c.GetName(), newVersion.String())
+			result := c.Result(updater.StatusAvailable, "dry run")
+			result.Version = newVersion.String()
+			report.Add(result)
+			continue
+		}
+		log.Infof("workload=%s container=%s going to update up to version %s", c.GetWorkloadName(), c.GetName(), newVersion.String())
+		updates.Add(c, *newVersion)
+	}
+
+	for _, u := range updates.Items {
+		results, err := u.Apply(k)
+		updated := 0
+		for _, result := range results {
+			if result.Status == updater.StatusUpdated {
+				updated++
 			}
-			log.Infof("deployment=%s container=%s going to update up to version %s", c.GetDeploymentName(), c.GetName(), newVersion.String())
-			c.UpdateDeployment(k, *newVersion)
+			report.Add(result)
+		}
+		if err != nil {
+			log.Errorf("workload=%s update failed: %s", u.GetWorkloadName(), err)
 		} else {
-			log.Debugf("deployment=%s container=%s nothing to update", c.GetDeploymentName(), c.GetName())
+			log.Infof("workload=%s updated %d containers", u.GetWorkloadName(), updated)
+		}
+	}
+
+	printReport(report)
+	if report.Failed(viper.GetBool("fail_on_skip")) {
+		return exitFailed
+	}
+	return exitOK
+}
+
+// printReport logs results which are not up to date and the summary at the end of the run
+func printReport(report *updater.Report) {
+	for _, result := range report.Results {
+		switch result.Status {
+		case updater.StatusFailed:
+			log.Errorln(result)
+		case updater.StatusSkipped:
+			log.Warnln(result)
+		case updater.StatusUpdated, updater.StatusAvailable:
+			log.Infoln(result)
 		}
 	}
+	log.Infoln(report.Summary())
 }
@@ -0,0 +1,162 @@
+// Package reference parses docker image references like `registry.example.com:5000/team/app:1.2.0@sha256:...`
+// following the grammar of github.com/docker/distribution/reference.
+package reference
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+const (
+	// DefaultDomain is a domain of images without explicit registry, e.g. `nginx`
+	DefaultDomain = "docker.io"
+	// OfficialRepoPrefix is a path prefix of Docker Hub official images
+	OfficialRepoPrefix = "library/"
+	// NameMaxLength is a maximum length of a reference name
+	NameMaxLength = 255
+)
+
+// Docker Hub domains normalized to `DefaultDomain`, as docker does
+var legacyDefaultDomains = map[string]bool{
+	"index.docker.io":      true,
+	"registry-1.docker.io": true,
+}
+
+var (
+	domainComponent = `(?:[a-zA-Z0-9]|[a-zA-Z0-9][a-zA-Z0-9-]*[a-zA-Z0-9])`
+	domainPattern   = domainComponent + `(?:\.` + domainComponent + `)*(?::[0-9]+)?`
+	pathComponent   = `[a-z0-9]+(?:(?:[._]|__|[-]*)[a-z0-9]+)*`
+
+	domainRegexp = regexp.MustCompile(`^` + domainPattern + `$`)
+	pathRegexp   = regexp.MustCompile(`^` + pathComponent + `(?:/` + pathComponent + `)*$`)
+	tagRegexp    = regexp.MustCompile(`^[\w][\w.-]{0,127}$`)
+	digestRegexp = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9]*(?:[-_+.][A-Za-z][A-Za-z0-9]*)*:[0-9a-fA-F]{32,}$`)
+)
+
+// Reference is a parsed image reference. `Domain` and `Path` are normalized,
+// so `nginx` and `index.docker.io/nginx` have domain `docker.io` and path `library/nginx`.
+type Reference struct {
+	Domain string
+	Path   string
+	Tag    string
+	Digest string
+
+	// name as it was written in the image reference
+	name string
+}
+
+// Parse parses an image reference
+func Parse(image string) (ref *Reference, err error) {
+	if image == "" {
+		err = fmt.Errorf("image reference is empty")
+		return
+	}
+	name := image
+	var tag, digest string
+
+	// Digest goes after `@`, ...
+	if i := strings.Index(name, "@"); i >= 0 {
+		digest = name[i+1:]
+		name = name[:i]
+		if !digestRegexp.MatchString(digest) {
+			err = fmt.Errorf("invalid digest in image reference '%s'", image)
+			return
+		}
+	}
+	// ... and tag is after the last `:` which is not a part of the domain port
+	if i := strings.LastIndex(name, ":"); i >= 0 && !strings.Contains(name[i+1:], "/") {
+		tag = name[i+1:]
+		name = name[:i]
+		if !tagRegexp.MatchString(tag) {
+			err = fmt.Errorf("invalid tag in image reference '%s'", image)
+			return
+		}
+	}
+	if len(name) > NameMaxLength {
+		err = fmt.Errorf("image name is longer than %d characters: '%s'", NameMaxLength, image)
+		return
+	}
+
+	domain, path := splitDomain(name)
+	if domain != "" && !domainRegexp.MatchString(domain) {
+		err = fmt.Errorf("invalid domain in image reference '%s'", image)
+		return
+	}
+	if !pathRegexp.MatchString(path) {
+		err = fmt.Errorf("invalid image name in reference '%s'", image)
+		return
+	}
+	if domain == "" || legacyDefaultDomains[domain] {
+		domain = DefaultDomain
+	}
+	if domain == DefaultDomain && !strings.Contains(path, "/") {
+		path = OfficialRepoPrefix + path
+	}
+
+	ref = &Reference{
+		Domain: domain,
+		Path:   path,
+		Tag:    tag,
+		Digest: digest,
+		name:   name,
+	}
+	return
+}
+
+// splitDomain splits a name into domain and path. The first name component is a domain
+// if it contains `.` or `:`, or it is `localhost`, as docker does.
+func splitDomain(name string) (domain, path string) {
+	i := strings.Index(name, "/")
+	if i < 0 {
+		return "", name
+	}
+	first := name[:i]
+	if first != "localhost" && !strings.ContainsAny(first, ".:") && strings.ToLower(first) == first {
+		return "", name
+	}
+	return first, name[i+1:]
+}
+
+// Name returns the image name as it was written, without tag and digest
+func (r *Reference) Name() string {
+	return r.name
+}
+
+// FullName returns the normalized image name, e.g. `docker.io/library/nginx`
+func (r *Reference) FullName() string {
+	return r.Domain + "/" + r.Path
+}
+
+// String returns the reference in the form it was written, e.g. `nginx:1.11`
+func (r *Reference) String() string {
+	s := r.name
+	if r.Tag != "" {
+		s += ":" + r.Tag
+	}
+	if r.Digest != "" {
+		s += "@" + r.Digest
+	}
+	return s
+}
+
+// WithTag returns a copy of the reference with the tag set. The digest is dropped
+// because it does not match the new tag.
+func (r *Reference) WithTag(tag string) *Reference {
+	ref := *r
+	ref.Tag = tag
+	ref.Digest = ""
+	return &ref
+}
+
+// WithDigest returns a copy of the reference with the digest set
+func (r *Reference) WithDigest(digest string) *Reference {
+	ref := *r
+	ref.Digest = digest
+	return &ref
+}
+
+// SameName checks if two references point to the same image repository
+func (r *Reference) SameName(other *Reference) bool {
+	return other != nil && r.FullName() == other.FullName()
+}
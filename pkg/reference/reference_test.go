@@ -0,0 +1,85 @@
+package reference
+
+import (
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+)
+
+const testDigest = "sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+
+func TestParse(t *testing.T) {
+	Convey("Test valid references", t, func() {
+		cases := []struct {
+			image  string
+			domain string
+			path   string
+			tag    string
+			digest string
+		}{
+			{"nginx", "docker.io", "library/nginx", "", ""},
+			{"nginx:1.11", "docker.io", "library/nginx", "1.11", ""},
+			{"sabaka/k8s-updater:0.0.1", "docker.io", "sabaka/k8s-updater", "0.0.1", ""},
+			{"docker.io/library/nginx:latest", "docker.io", "library/nginx", "latest", ""},
+			{"index.docker.io/nginx:1.11", "docker.io", "library/nginx", "1.11", ""},
+			{"registry-1.docker.io/sabaka/k8s-updater", "docker.io", "sabaka/k8s-updater", "", ""},
+			{"registry.example.com/my-image:1.2.3", "registry.example.com", "my-image", "1.2.3", ""},
+			{"registry.example.com/team/app/web:v2.3.4", "registry.example.com", "team/app/web", "v2.3.4", ""},
+			{"localhost:5000/app:1.2.0", "localhost:5000", "app", "1.2.0", ""},
+			{"localhost:5000/app", "localhost:5000", "app", "", ""},
+			{"localhost/app:1.0", "localhost", "app", "1.0", ""},
+			{"app@" + testDigest, "docker.io", "library/app", "", testDigest},
+			{"app:1.2.0@" + testDigest, "docker.io", "library/app", "1.2.0", testDigest},
+			{"localhost:5000/app:1.2.0@" + testDigest, "localhost:5000", "app", "1.2.0", testDigest},
+			{"gcr.io/project/my_app-name:1.0.0-rc.1", "gcr.io", "project/my_app-name", "1.0.0-rc.1", ""},
+		}
+		for _, c := range cases {
+			ref, err := Parse(c.image)
+			So(err, ShouldBeNil)
+			So(ref.Domain, ShouldEqual, c.domain)
+			So(ref.Path, ShouldEqual, c.path)
+			So(ref.Tag, ShouldEqual, c.tag)
+			So(ref.Digest, ShouldEqual, c.digest)
+			// The reference is written back as it was
+			So(ref.String(), ShouldEqual, c.image)
+		}
+	})
+
+	Convey("Test invalid references", t, func() {
+		cases := []string{
+			"",
+			"Nginx",
+			"app:",
+			"app@sha256:abc",
+			"app:-tag",
+			"registry.example.com/App:1.0",
+			"-registry.example.com/app:1.0",
+			"app//web:1.0",
+			"app:1.0:2.0",
+		}
+		for _, image := range cases {
+			ref, err := Parse(image)
+			So(err, ShouldNotBeNil)
+			So(ref, ShouldBeNil)
+		}
+	})
+
+	Convey("Test reference update", t, func() {
+		ref, err := Parse("localhost:5000/app:1.2.0@" + testDigest)
+		So(err, ShouldBeNil)
+		So(ref.Name(), ShouldEqual, "localhost:5000/app")
+		So(ref.FullName(), ShouldEqual, "localhost:5000/app")
+		So(ref.WithTag("1.3.0").String(), ShouldEqual, "localhost:5000/app:1.3.0")
+		So(ref.WithTag("1.3.0").WithDigest(testDigest).String(), ShouldEqual, "localhost:5000/app:1.3.0@"+testDigest)
+		// The original reference is not changed
+		So(ref.String(), ShouldEqual, "localhost:5000/app:1.2.0@"+testDigest)
+
+		nginx, err := Parse("nginx:1.11")
+		So(err, ShouldBeNil)
+		So(nginx.FullName(), ShouldEqual, "docker.io/library/nginx")
+		So(nginx.WithTag("1.12").String(), ShouldEqual, "nginx:1.12")
+
+		hub, err := Parse("docker.io/library/nginx:1.10")
+		So(err, ShouldBeNil)
+		So(nginx.SameName(hub), ShouldBeTrue)
+	})
+}
@@ -0,0 +1,204 @@
+package registry
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Token lifetime to assume if a token server does not report `expires_in`,
+// see https://docs.docker.com/registry/spec/auth/token/
+const defaultTokenExpiration = 60 * time.Second
+
+// Challenge is a parsed `WWW-Authenticate` response header
+type Challenge struct {
+	Scheme     string
+	Parameters map[string]string
+}
+
+// token is a bearer token issued by a registry authorization service
+type token struct {
+	Token       string    `json:"token"`
+	AccessToken string    `json:"access_token"`
+	ExpiresIn   int       `json:"expires_in"`
+	IssuedAt    time.Time `json:"issued_at"`
+
+	expires time.Time
+}
+
+// valid checks if the token could be used for one more request
+func (t *token) valid() bool {
+	// Leave a few seconds to avoid a token to expire on the way
+	return t != nil && time.Now().Add(5*time.Second).Before(t.expires)
+}
+
+// ParseChallenges returns all challenges from `WWW-Authenticate` headers of the response
+func ParseChallenges(header http.Header) (challenges []*Challenge) {
+	for _, h := range header[http.CanonicalHeaderKey("WWW-Authenticate")] {
+		if c := parseChallenge(h); c != nil {
+			challenges = append(challenges, c)
+		}
+	}
+	return
+}
+
+// parseChallenge parses a challenge like `Bearer realm="...",service="...",scope="..."`
+func parseChallenge(header string) *Challenge {
+	header = strings.TrimSpace(header)
+	i := strings.IndexByte(header, ' ')
+	if i < 0 {
+		if header == "" {
+			return nil
+		}
+		return &Challenge{Scheme: strings.ToLower(header), Parameters: map[string]string{}}
+	}
+	c := &Challenge{
+		Scheme:     strings.ToLower(header[:i]),
+		Parameters: make(map[string]string),
+	}
+	s := header[i+1:]
+	for {
+		s = strings.TrimLeft(s, " ,")
+		if s == "" {
+			break
+		}
+		eq := strings.IndexByte(s, '=')
+		if eq < 0 {
+			break
+		}
+		key := strings.ToLower(strings.TrimSpace(s[:eq]))
+		s = strings.TrimLeft(s[eq+1:], " ")
+		var value string
+		if strings.HasPrefix(s, `"`) {
+			// Quoted value may contain commas and escaped quotes
+			var b bytes.Buffer
+			j := 1
+			for ; j < len(s); j++ {
+				if s[j] == '\\' && j+1 < len(s) {
+					j++
+					b.WriteByte(s[j])
+					continue
+				}
+				if s[j] == '"' {
+					break
+				}
+				b.WriteByte(s[j])
+			}
+			value = b.String()
+			if j < len(s) {
+				j++
+			}
+			s = s[j:]
+		} else {
+			end := strings.IndexByte(s, ',')
+			if end < 0 {
+				end = len(s)
+			}
+			value = strings.TrimSpace(s[:end])
+			s = s[end:]
+		}
+		c.Parameters[key] = value
+	}
+	return c
+}
+
+// fetchToken requests a bearer token from the authorization service the challenge points to
+func (t *AuthTransport) fetchToken(c *Challenge, scope string) (tok *token, err error) {
+	realm, ok := c.Parameters["realm"]
+	if !ok {
+		err = fmt.Errorf("bearer challenge has no realm")
+		return
+	}
+	u, err := url.Parse(realm)
+	if err != nil {
+		return
+	}
+	q := u.Query()
+	if service, ok := c.Parameters["service"]; ok && service != "" {
+		q.Set("service", service)
+	}
+	if scope != "" {
+		q.Set("scope", scope)
+	}
+
+	var req *http.Request
+	if t.IdentityToken != "" {
+		// OAuth2 flow, see https://docs.docker.com/registry/spec/auth/oauth/
+		form := url.Values{
+			"grant_type":    {"refresh_token"},
+			"refresh_token": {t.IdentityToken},
+			"client_id":     {DefaultUserAgent},
+		}
+		for key, values := range q {
+			form[key] = values
+		}
+		req, err = http.NewRequest("POST", u.String(), strings.NewReader(form.Encode()))
+		if err != nil {
+			return
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	} else {
+		u.RawQuery = q.Encode()
+		req, err = http.NewRequest("GET", u.String(), nil)
+		if err != nil {
+			return
+		}
+		if t.Username != "" || t.Password != "" {
+			req.SetBasicAuth(t.Username, t.Password)
+		}
+	}
+	if t.UserAgent != "" {
+		req.Header.Set("User-Agent", t.UserAgent)
+	}
+	res, err := t.transport().RoundTrip(req)
+	if err != nil {
+		return
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		err = fmt.Errorf("cannot get token from '%s': %s", realm, res.Status)
+		return
+	}
+
+	tok = new(token)
+	if err = json.NewDecoder(res.Body).Decode(tok); err != nil {
+		tok = nil
+		return
+	}
+	if tok.Token == "" {
+		tok.Token = tok.AccessToken
+	}
+	if tok.Token == "" {
+		err = fmt.Errorf("token server '%s' returned an empty token", realm)
+		tok = nil
+		return
+	}
+	expiresIn := time.Duration(tok.ExpiresIn) * time.Second
+	if expiresIn <= 0 {
+		expiresIn = defaultTokenExpiration
+	}
+	issuedAt := tok.IssuedAt
+	if issuedAt.IsZero() || issuedAt.After(time.Now()) {
+		issuedAt = time.Now()
+	}
+	tok.expires = issuedAt.Add(expiresIn)
+	return
+}
+
+// scopeFromPath guesses a pull scope for a registry API path like `/v2/<name>/tags/list`
+func scopeFromPath(path string) string {
+	if !strings.HasPrefix(path, "/v2/") {
+		return ""
+	}
+	name := strings.TrimPrefix(path, "/v2/")
+	for _, sep := range []string{"/tags/", "/manifests/", "/blobs/"} {
+		if i := strings.LastIndex(name, sep); i > 0 {
+			return "repository:" + name[:i] + ":pull"
+		}
+	}
+	return ""
+}
@@ -0,0 +1,124 @@
+package registry
+
+import (
+	"fmt"
+	. "github.com/smartystreets/goconvey/convey"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestAuth(t *testing.T) {
+	Convey("Test challenge parsing", t, func() {
+		header := http.Header{}
+		header.Add("WWW-Authenticate", `Bearer realm="https://auth.docker.io/token",service="registry.docker.io",scope="repository:library/nginx:pull,push"`)
+		header.Add("WWW-Authenticate", `Basic realm=Registry`)
+
+		challenges := ParseChallenges(header)
+		So(challenges, ShouldHaveLength, 2)
+		So(challenges[0].Scheme, ShouldEqual, "bearer")
+		So(challenges[0].Parameters["realm"], ShouldEqual, "https://auth.docker.io/token")
+		So(challenges[0].Parameters["service"], ShouldEqual, "registry.docker.io")
+		So(challenges[0].Parameters["scope"], ShouldEqual, "repository:library/nginx:pull,push")
+		So(challenges[1].Scheme, ShouldEqual, "basic")
+		So(challenges[1].Parameters["realm"], ShouldEqual, "Registry")
+	})
+
+	Convey("Test scope from path", t, func() {
+		So(scopeFromPath("/v2/library/nginx/tags/list"), ShouldEqual, "repository:library/nginx:pull")
+		So(scopeFromPath("/v2/app/manifests/1.0.0"), ShouldEqual, "repository:app:pull")
+		So(scopeFromPath("/v2/"), ShouldEqual, "")
+	})
+
+	Convey("Test bearer token flow", t, func() {
+		tokenRequests := 0
+		var server *httptest.Server
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/token" && r.Method == "POST" {
+				r.ParseForm()
+				if r.PostForm.Get("grant_type") != "refresh_token" || r.PostForm.Get("refresh_token") != "refresh" {
+					w.WriteHeader(http.StatusUnauthorized)
+					return
+				}
+				tokenRequests++
+				fmt.Fprintf(w, `{"access_token": "token-for-%s", "expires_in": 300}`, r.PostForm.Get("scope"))
+				return
+			}
+			if r.URL.Path == "/token" {
+				user, pass, _ := r.BasicAuth()
+				if user != "user" || pass != "secret" {
+					w.WriteHeader(http.StatusUnauthorized)
+					return
+				}
+				tokenRequests++
+				fmt.Fprintf(w, `{"token": "token-for-%s", "expires_in": 300}`, r.URL.Query().Get("scope"))
+				return
+			}
+			if r.Header.Get("Authorization") != "Bearer token-for-repository:app:pull" {
+				w.Header().Set("WWW-Authenticate", fmt.Sprintf(
+					`Bearer realm="%s/token",service="test",scope="repository:app:pull"`, server.URL))
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			fmt.Fprint(w, `{"name": "app", "tags": ["1.0.0"]}`)
+		}))
+		defer server.Close()
+
+		client := &http.Client{Transport: &AuthTransport{Username: "user", Password: "secret"}}
+		for i := 0; i < 3; i++ {
+			res, err := client.Get(server.URL + "/v2/app/tags/list")
+			So(err, ShouldBeNil)
+			res.Body.Close()
+			So(res.StatusCode, ShouldEqual, http.StatusOK)
+		}
+		// The token is cached until it expires
+		So(tokenRequests, ShouldEqual, 1)
+
+		// An identity token is exchanged for a registry token
+		client = &http.Client{Transport: &AuthTransport{IdentityToken: "refresh"}}
+		res, err := client.Get(server.URL + "/v2/app/tags/list")
+		So(err, ShouldBeNil)
+		res.Body.Close()
+		So(res.StatusCode, ShouldEqual, http.StatusOK)
+		So(tokenRequests, ShouldEqual, 2)
+	})
+
+	Convey("Test token of a challenge scope different from the path scope", t, func() {
+		tokenRequests := 0
+		var server *httptest.Server
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/token" {
+				tokenRequests++
+				fmt.Fprintf(w, `{"token": "token-for-%s", "expires_in": 300}`, r.URL.Query().Get("scope"))
+				return
+			}
+			if r.Header.Get("Authorization") != "Bearer token-for-repository:app:pull,push" {
+				w.Header().Set("WWW-Authenticate", fmt.Sprintf(
+					`Bearer realm="%s/token",service="test",scope="repository:app:pull,push"`, server.URL))
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			fmt.Fprint(w, `{"name": "app", "tags": ["1.0.0"]}`)
+		}))
+		defer server.Close()
+
+		transport := &AuthTransport{}
+		client := &http.Client{Transport: transport}
+		for i := 0; i < 3; i++ {
+			res, err := client.Get(server.URL + "/v2/app/tags/list")
+			So(err, ShouldBeNil)
+			res.Body.Close()
+			So(res.StatusCode, ShouldEqual, http.StatusOK)
+		}
+		So(tokenRequests, ShouldEqual, 1)
+
+		// An expired token is requested again with the challenge scope
+		transport.tokens["repository:app:pull"].expires = time.Now().Add(-time.Minute)
+		res, err := client.Get(server.URL + "/v2/app/tags/list")
+		So(err, ShouldBeNil)
+		res.Body.Close()
+		So(res.StatusCode, ShouldEqual, http.StatusOK)
+		So(tokenRequests, ShouldEqual, 2)
+	})
+}
@@ -0,0 +1,203 @@
+package registry
+
+import (
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"k8s.io/kubernetes/pkg/api"
+	client "k8s.io/kubernetes/pkg/client/unversioned"
+	"sync"
+	"time"
+)
+
+// DefaultCache is shared by all deployments checked by the process
+var DefaultCache = NewCache(0, false)
+
+// Cache shares image pull secrets credentials and registry clients between deployments,
+// so a tag list of an image is fetched once even if many deployments run the image.
+// Registries cache tag lists and digests themselves.
+type Cache struct {
+	// TTL is a lifetime of cached entries, 0 to keep them for the cache lifetime (one run)
+	TTL time.Duration
+	// Revalidate expired tag lists with `If-None-Match` request
+	Revalidate bool
+
+	mu              sync.Mutex
+	credentials     map[string]*credentialsEntry
+	serviceAccounts map[string]*serviceAccountEntry
+	registries      map[string]*Registry
+}
+
+// credentialsEntry is a cached image pull secret
+type credentialsEntry struct {
+	credentials map[string]*Credentials
+	fetched     time.Time
+}
+
+// serviceAccountEntry is cached image pull secrets of a service account
+type serviceAccountEntry struct {
+	secrets []api.LocalObjectReference
+	fetched time.Time
+}
+
+// NewCache returns a new cache with the entries lifetime
+func NewCache(ttl time.Duration, revalidate bool) *Cache {
+	return &Cache{
+		TTL:             ttl,
+		Revalidate:      revalidate,
+		credentials:     make(map[string]*credentialsEntry),
+		serviceAccounts: make(map[string]*serviceAccountEntry),
+		registries:      make(map[string]*Registry),
+	}
+}
+
+// GetRegistry returns a registry with given name and credentials. Registries with the same
+// host and credentials are shared, so they share cached tag lists and digests.
+func (c *Cache) GetRegistry(name string, credentials *Credentials) (r *Registry, err error) {
+	if credentials == nil {
+		credentials = new(Credentials)
+	}
+	if err = credentials.decode(); err != nil {
+		return
+	}
+	key := NormalizeHost(name) + "\x00" + credentials.Username + "\x00" + credentials.Password + "\x00" + credentials.IdentityToken
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if r = c.registries[key]; r != nil {
+		return
+	}
+	r, err = NewRegistry(name, credentials)
+	if err != nil {
+		return
+	}
+	r.CacheTTL = c.TTL
+	r.Revalidate = c.Revalidate
+	c.registries[key] = r
+	return
+}
+
+// GetCredentials returns credentials by registry host from the image pull secret
+func (c *Cache) GetCredentials(k *client.Client, namespace, name string) (credentials map[string]*Credentials, err error) {
+	key := namespace + "/" + name
+	c.mu.Lock()
+	cached := c.credentials[key]
+	c.mu.Unlock()
+	if cached != nil && c.fresh(cached.fetched) {
+		credentials = cached.credentials
+		return
+	}
+
+	secret, err := k.Secrets(namespace).Get(name)
+	if err != nil {
+		return
+	}
+	credentials, err = ParseDockerConfig(secret)
+	if err != nil {
+		return
+	}
+	c.mu.Lock()
+	c.credentials[key] = &credentialsEntry{credentials: credentials, fetched: time.Now()}
+	c.mu.Unlock()
+	return
+}
+
+// GetServiceAccountSecrets returns image pull secrets of the service account
+func (c *Cache) GetServiceAccountSecrets(k *client.Client, namespace, name string) (secrets []api.LocalObjectReference, err error) {
+	key := namespace + "/" + name
+	c.mu.Lock()
+	cached := c.serviceAccounts[key]
+	c.mu.Unlock()
+	if cached != nil && c.fresh(cached.fetched) {
+		secrets = cached.secrets
+		return
+	}
+
+	account, err := k.ServiceAccounts(namespace).Get(name)
+	if err != nil {
+		return
+	}
+	secrets = account.ImagePullSecrets
+	c.mu.Lock()
+	c.serviceAccounts[key] = &serviceAccountEntry{secrets: secrets, fetched: time.Now()}
+	c.mu.Unlock()
+	return
+}
+
+func (c *Cache) fresh(fetched time.Time) bool {
+	return c.TTL == 0 || time.Since(fetched) <= c.TTL
+}
+
+// GetRegistries returns list of registries based on image pull secrets of the pod template,
+// then image pull secrets of its service account. A service account which could not be read is logged,
+// e.g. without permission to get service accounts, and registries of the pod template secrets are returned.
+func (c *Cache) GetRegistries(k *client.Client, namespace string, template *api.PodTemplateSpec) (registries *RegistryList, err error) {
+	spec := template.Spec
+	registries = new(RegistryList)
+	if err = c.addRegistries(k, registries, namespace, spec.ImagePullSecrets, "image pull secret"); err != nil {
+		return
+	}
+
+	account := spec.ServiceAccountName
+	if account == "" {
+		account = "default"
+	}
+	secrets, err := c.GetServiceAccountSecrets(k, namespace, account)
+	if err != nil {
+		log.Warnf("namespace=%s cannot get service account '%s', its image pull secrets are not used: %s", namespace, account, err)
+		err = nil
+		return
+	}
+	err = c.addRegistries(k, registries, namespace, secrets, fmt.Sprintf("service account '%s' image pull secret", account))
+	return
+}
+
+// addRegistries adds registries of the image pull secrets to the list, `from` describes the secrets in logs
+func (c *Cache) addRegistries(k *client.Client, registries *RegistryList, namespace string, secrets []api.LocalObjectReference, from string) (err error) {
+	for _, s := range secrets {
+		credentialsMap, e := c.GetCredentials(k, namespace, s.Name)
+		if e != nil {
+			err = fmt.Errorf("cannot get image pull secret '%s': %s", s.Name, e)
+			return
+		}
+		for name, creds := range credentialsMap {
+			r, e := c.GetRegistry(name, creds)
+			if e != nil {
+				err = e
+				return
+			}
+			registries.add(r, fmt.Sprintf("%s '%s'", from, s.Name))
+		}
+	}
+	return
+}
+
+// SecretProvider provides credentials of an image pull secret, e.g. a fallback secret for all deployments
+type SecretProvider struct {
+	Client    *client.Client
+	Namespace string
+	Name      string
+	// Cache to get the secret with, `DefaultCache` if nil
+	Cache *Cache
+}
+
+// GetCredentials implements CredentialProvider
+func (p *SecretProvider) GetCredentials(host string) (*Credentials, error) {
+	cache := p.Cache
+	if cache == nil {
+		cache = DefaultCache
+	}
+	credentials, err := cache.GetCredentials(p.Client, p.Namespace, p.Name)
+	if err != nil {
+		return nil, err
+	}
+	return credentials[NormalizeHost(host)], nil
+}
+
+func (p *SecretProvider) String() string {
+	return fmt.Sprintf("secret '%s/%s'", p.Namespace, p.Name)
+}
+
+// GetRegistry returns a registry with given name and credentials from `DefaultCache`
+func GetRegistry(name string, credentials *Credentials) (*Registry, error) {
+	return DefaultCache.GetRegistry(name, credentials)
+}
@@ -0,0 +1,83 @@
+package registry
+
+import (
+	"encoding/json"
+	. "github.com/smartystreets/goconvey/convey"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCache(t *testing.T) {
+	tagRequests := 0
+	notModified := 0
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tagRequests++
+		w.Header().Set("ETag", `"v1"`)
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			notModified++
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		json.NewEncoder(w).Encode(&TagsList{Name: "app", Tags: []string{"1.0.0", "1.1.0"}})
+	}))
+	defer server.Close()
+
+	Convey("Test registries cache", t, func() {
+		tagRequests = 0
+		notModified = 0
+		cache := NewCache(0, false)
+		creds := &Credentials{Username: "user", Password: "secret"}
+
+		r1, err := cache.GetRegistry("registry.example.com", creds)
+		So(err, ShouldBeNil)
+		r2, err := cache.GetRegistry("https://registry.example.com/v1/", &Credentials{Username: "user", Password: "secret"})
+		So(err, ShouldBeNil)
+		So(r2, ShouldEqual, r1)
+
+		other, err := cache.GetRegistry("registry.example.com", nil)
+		So(err, ShouldBeNil)
+		So(other, ShouldNotEqual, r1)
+
+		Convey("Pull secrets of the pod template go before service account ones", func() {
+			list := new(RegistryList)
+			list.add(r1, "image pull secret 'app'")
+			list.add(other, "service account 'default' image pull secret 'shared'")
+			So(list.Items, ShouldHaveLength, 1)
+
+			r, from, err := list.Lookup("registry.example.com")
+			So(err, ShouldBeNil)
+			So(r, ShouldEqual, r1)
+			So(from, ShouldEqual, "image pull secret 'app'")
+
+			_, from, err = list.Lookup("public.example.com")
+			So(err, ShouldBeNil)
+			So(from, ShouldEqual, "anonymous access")
+		})
+
+		Convey("Tag list is fetched once", func() {
+			r := newTestRegistry(server)
+			for i := 0; i < 3; i++ {
+				tags, err := r.GetTags("app")
+				So(err, ShouldBeNil)
+				So(tags, ShouldResemble, []string{"1.0.0", "1.1.0"})
+			}
+			So(tagRequests, ShouldEqual, 1)
+		})
+
+		Convey("Expired tag list is revalidated", func() {
+			r := newTestRegistry(server)
+			r.CacheTTL = time.Millisecond
+			r.Revalidate = true
+			_, err := r.GetTags("app")
+			So(err, ShouldBeNil)
+			time.Sleep(5 * time.Millisecond)
+			tags, err := r.GetTags("app")
+			So(err, ShouldBeNil)
+			So(tags, ShouldResemble, []string{"1.0.0", "1.1.0"})
+			So(tagRequests, ShouldEqual, 2)
+			So(notModified, ShouldEqual, 1)
+		})
+	})
+}
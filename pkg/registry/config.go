@@ -0,0 +1,161 @@
+package registry
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// DefaultUserAgent is sent with registry API requests if a registry config does not set one
+const DefaultUserAgent = "k8s-updater"
+
+// Config is a registry connection configuration. `Host` "*" configures all registries
+// without their own configuration.
+type Config struct {
+	Host string `mapstructure:"host"`
+	// Scheme is `https` by default, use `http` for insecure in-cluster registries
+	Scheme string `mapstructure:"scheme"`
+	// CAFile is a PEM bundle to verify the registry certificate with instead of system roots
+	CAFile string `mapstructure:"ca_file"`
+	// CertFile and KeyFile is a client certificate to authenticate with
+	CertFile           string `mapstructure:"cert_file"`
+	KeyFile            string `mapstructure:"key_file"`
+	InsecureSkipVerify bool   `mapstructure:"insecure_skip_verify"`
+	// Proxy URL, proxy environment variables are used if empty
+	Proxy string `mapstructure:"proxy"`
+	// Timeout of a request, e.g. `30s`
+	Timeout   string `mapstructure:"timeout"`
+	UserAgent string `mapstructure:"user_agent"`
+	// RequestBudget limits a number of requests to the registry, `DefaultRequestBudget` is used if 0
+	RequestBudget int `mapstructure:"request_budget"`
+	// CredentialHelper is a docker credential helper to get credentials with, e.g. `ecr-login`
+	CredentialHelper string `mapstructure:"credential_helper"`
+}
+
+// configs are registry configurations by normalized host
+var configs = map[string]*Config{}
+
+// SetConfigs validates and sets registry configurations
+func SetConfigs(list []*Config) error {
+	byHost := make(map[string]*Config)
+	for _, cfg := range list {
+		if cfg.Host == "" {
+			return fmt.Errorf("registry config has no host")
+		}
+		if err := cfg.validate(); err != nil {
+			return fmt.Errorf("invalid config for registry '%s': %s", cfg.Host, err)
+		}
+		host := cfg.Host
+		if host != "*" {
+			host = NormalizeHost(host)
+		}
+		byHost[host] = cfg
+	}
+	configs = byHost
+	return nil
+}
+
+// GetConfig returns configuration for the registry host
+func GetConfig(host string) *Config {
+	if cfg, ok := configs[NormalizeHost(host)]; ok {
+		return cfg
+	}
+	if cfg, ok := configs["*"]; ok {
+		return cfg
+	}
+	return &Config{}
+}
+
+func (cfg *Config) validate() error {
+	if cfg.Scheme != "" && cfg.Scheme != "http" && cfg.Scheme != "https" {
+		return fmt.Errorf("unknown scheme '%s'", cfg.Scheme)
+	}
+	if (cfg.CertFile == "") != (cfg.KeyFile == "") {
+		return fmt.Errorf("both cert_file and key_file should be set")
+	}
+	if cfg.Proxy != "" {
+		if _, err := url.Parse(cfg.Proxy); err != nil {
+			return fmt.Errorf("invalid proxy: %s", err)
+		}
+	}
+	if cfg.Timeout != "" {
+		if _, err := time.ParseDuration(cfg.Timeout); err != nil {
+			return fmt.Errorf("invalid timeout: %s", err)
+		}
+	}
+	return nil
+}
+
+// GetScheme returns URL scheme for registry API requests
+func (cfg *Config) GetScheme() string {
+	if cfg.Scheme == "" {
+		return "https"
+	}
+	return cfg.Scheme
+}
+
+// GetUserAgent returns User-Agent header for registry API requests
+func (cfg *Config) GetUserAgent() string {
+	if cfg.UserAgent == "" {
+		return DefaultUserAgent
+	}
+	return cfg.UserAgent
+}
+
+// GetTimeout returns a request timeout, 0 is no timeout
+func (cfg *Config) GetTimeout() time.Duration {
+	timeout, _ := time.ParseDuration(cfg.Timeout)
+	return timeout
+}
+
+// NewTransport returns http transport with TLS and proxy configuration
+func (cfg *Config) NewTransport() (transport *http.Transport, err error) {
+	tlsConfig := &tls.Config{InsecureSkipVerify: cfg.InsecureSkipVerify}
+	if cfg.CAFile != "" {
+		pem, e := ioutil.ReadFile(cfg.CAFile)
+		if e != nil {
+			err = fmt.Errorf("cannot read CA file: %s", e)
+			return
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			err = fmt.Errorf("no certificates found in CA file '%s'", cfg.CAFile)
+			return
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if cfg.CertFile != "" {
+		cert, e := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+		if e != nil {
+			err = fmt.Errorf("cannot load client certificate: %s", e)
+			return
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	proxy := http.ProxyFromEnvironment
+	if cfg.Proxy != "" {
+		proxyURL, e := url.Parse(cfg.Proxy)
+		if e != nil {
+			err = fmt.Errorf("invalid proxy: %s", e)
+			return
+		}
+		proxy = http.ProxyURL(proxyURL)
+	}
+
+	transport = &http.Transport{
+		Proxy: proxy,
+		Dial: (&net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).Dial,
+		TLSClientConfig:     tlsConfig,
+		TLSHandshakeTimeout: 10 * time.Second,
+	}
+	return
+}
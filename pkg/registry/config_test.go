@@ -0,0 +1,61 @@
+package registry
+
+import (
+	"encoding/json"
+	. "github.com/smartystreets/goconvey/convey"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestConfig(t *testing.T) {
+	Convey("Test registry configs", t, func() {
+		defer SetConfigs(nil)
+
+		Convey("Invalid configs are rejected", func() {
+			So(SetConfigs([]*Config{{Scheme: "http"}}), ShouldNotBeNil)
+			So(SetConfigs([]*Config{{Host: "localhost:5000", Scheme: "ftp"}}), ShouldNotBeNil)
+			So(SetConfigs([]*Config{{Host: "localhost:5000", CertFile: "cert.pem"}}), ShouldNotBeNil)
+			So(SetConfigs([]*Config{{Host: "localhost:5000", Timeout: "soon"}}), ShouldNotBeNil)
+		})
+
+		Convey("Configs are matched by normalized host", func() {
+			err := SetConfigs([]*Config{
+				{Host: "https://index.docker.io/v1/", Timeout: "10s"},
+				{Host: "*", UserAgent: "custom"},
+			})
+			So(err, ShouldBeNil)
+			So(GetConfig("docker.io").GetTimeout().Seconds(), ShouldEqual, 10)
+			So(GetConfig("docker.io").GetUserAgent(), ShouldEqual, DefaultUserAgent)
+			So(GetConfig("registry.example.com").GetUserAgent(), ShouldEqual, "custom")
+			So(GetConfig("registry.example.com").GetScheme(), ShouldEqual, "https")
+		})
+
+		Convey("Plain HTTP registry with custom User-Agent", func() {
+			var userAgent string
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				userAgent = r.Header.Get("User-Agent")
+				json.NewEncoder(w).Encode(&TagsList{Name: "app", Tags: []string{"1.0.0"}})
+			}))
+			defer server.Close()
+			host := strings.TrimPrefix(server.URL, "http://")
+
+			err := SetConfigs([]*Config{{Host: host, Scheme: "http", UserAgent: "updater-test"}})
+			So(err, ShouldBeNil)
+			r, err := NewRegistry(host, nil)
+			So(err, ShouldBeNil)
+			tags, err := r.GetTags("app")
+			So(err, ShouldBeNil)
+			So(tags, ShouldResemble, []string{"1.0.0"})
+			So(userAgent, ShouldEqual, "updater-test")
+		})
+
+		Convey("Expect error for missing CA file", func() {
+			err := SetConfigs([]*Config{{Host: "registry.example.com", CAFile: "/nonexistent/ca.pem"}})
+			So(err, ShouldBeNil)
+			_, err = NewRegistry("registry.example.com", nil)
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
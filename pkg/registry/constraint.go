@@ -0,0 +1,233 @@
+package registry
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/blang/semver"
+	"strconv"
+	"strings"
+)
+
+// ParseConstraint parses a semver range in npm syntax as well as `semver.ParseRange` one:
+//
+//	^1.2.3     >=1.2.3 <2.0.0, `^0.2.3` is >=0.2.3 <0.3.0
+//	~1.2.3     >=1.2.3 <1.3.0
+//	1.x, 1.2.* >=1.0.0 <2.0.0, >=1.2.0 <1.3.0
+//	1.2 - 1.5  >=1.2.0 <1.6.0
+//	>1.2       >=1.3.0
+//
+// Space separated comparators are combined with AND, `||` is OR.
+func ParseConstraint(s string) (semver.Range, error) {
+	expr, err := translateConstraint(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid version range '%s': %s", s, err)
+	}
+	r, err := semver.ParseRange(expr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid version range '%s': %s", s, err)
+	}
+	return r, nil
+}
+
+// translateConstraint rewrites npm constraint syntax to `semver.ParseRange` one
+func translateConstraint(s string) (string, error) {
+	var groups []string
+	for _, group := range strings.Split(s, "||") {
+		var comparators []string
+		tokens := joinOperators(strings.Fields(group))
+		if len(tokens) == 0 {
+			return "", fmt.Errorf("empty range")
+		}
+		for i := 0; i < len(tokens); i++ {
+			// Hyphen range `A - B`
+			if i+2 < len(tokens) && tokens[i+1] == "-" {
+				c, err := hyphenRange(tokens[i], tokens[i+2])
+				if err != nil {
+					return "", err
+				}
+				comparators = append(comparators, c...)
+				i += 2
+				continue
+			}
+			c, err := translateComparator(tokens[i])
+			if err != nil {
+				return "", err
+			}
+			comparators = append(comparators, c...)
+		}
+		if len(comparators) == 0 {
+			// `*` matches all versions
+			comparators = []string{">=0.0.0"}
+		}
+		groups = append(groups, strings.Join(comparators, " "))
+	}
+	return strings.Join(groups, " || "), nil
+}
+
+// joinOperators joins operators separated from versions with a space, e.g. `>= 1.2`
+func joinOperators(tokens []string) (joined []string) {
+	for i := 0; i < len(tokens); i++ {
+		t := tokens[i]
+		if strings.Trim(t, "<>=!~^") == "" && t != "" && i+1 < len(tokens) {
+			t += tokens[i+1]
+			i++
+		}
+		joined = append(joined, t)
+	}
+	return
+}
+
+// partial is a version which may miss minor and patch or have them as `x` or `*`
+type partial struct {
+	// numbers are set parts, missing and wildcard ones are omitted
+	numbers []uint64
+	// rest is a prerelease and build metadata part, e.g. `-rc.1`
+	rest string
+}
+
+func parsePartial(s string) (p partial, err error) {
+	s = strings.TrimPrefix(strings.TrimPrefix(s, "="), "v")
+	if i := strings.IndexAny(s, "-+"); i >= 0 {
+		p.rest = s[i:]
+		s = s[:i]
+	}
+	parts := strings.Split(s, ".")
+	if len(parts) > 3 {
+		err = fmt.Errorf("too many version parts in '%s'", s)
+		return
+	}
+	for _, part := range parts {
+		if part == "x" || part == "X" || part == "*" || part == "" {
+			break
+		}
+		n, e := strconv.ParseUint(part, 10, 64)
+		if e != nil {
+			err = fmt.Errorf("invalid version part '%s'", part)
+			return
+		}
+		p.numbers = append(p.numbers, n)
+	}
+	if p.rest != "" && len(p.numbers) < 3 {
+		err = fmt.Errorf("prerelease of incomplete version '%s'", s+p.rest)
+	}
+	return
+}
+
+// full returns the version with missing parts set to 0
+func (p partial) full() string {
+	var b bytes.Buffer
+	for i := 0; i < 3; i++ {
+		if i > 0 {
+			b.WriteByte('.')
+		}
+		if i < len(p.numbers) {
+			b.WriteString(strconv.FormatUint(p.numbers[i], 10))
+		} else {
+			b.WriteByte('0')
+		}
+	}
+	b.WriteString(p.rest)
+	return b.String()
+}
+
+// next returns the least version greater than all versions matching the partial one,
+// incrementing the part at the index, e.g. `1.3.0` for `1.2` and index 1.
+// It is an exclusive upper bound, so it is compared as `1.3.0-0` to exclude `1.3.0-rc.1` as npm does.
+func (p partial) next(index int) string {
+	numbers := make([]uint64, 3)
+	copy(numbers, p.numbers)
+	numbers[index]++
+	for i := index + 1; i < 3; i++ {
+		numbers[i] = 0
+	}
+	return fmt.Sprintf("%d.%d.%d", numbers[0], numbers[1], numbers[2])
+}
+
+func translateComparator(s string) ([]string, error) {
+	op := s[:len(s)-len(strings.TrimLeft(s, "<>=!~^"))]
+	p, err := parsePartial(s[len(op):])
+	if err != nil {
+		return nil, err
+	}
+	n := len(p.numbers)
+
+	switch op {
+	case "^":
+		if n == 0 {
+			return nil, nil
+		}
+		// The first non-zero part is fixed
+		index := 0
+		for index < n-1 && p.numbers[index] == 0 {
+			index++
+		}
+		if n < 3 && index == n-1 && p.numbers[index] == 0 {
+			// `^0.x` and `^0.0` fix the parts given only
+			return []string{">=" + p.full(), "<" + p.next(n-1) + "-0"}, nil
+		}
+		return []string{">=" + p.full(), "<" + p.next(index) + "-0"}, nil
+	case "~", "~>":
+		if n == 0 {
+			return nil, nil
+		}
+		if n == 1 {
+			return []string{">=" + p.full(), "<" + p.next(0) + "-0"}, nil
+		}
+		return []string{">=" + p.full(), "<" + p.next(1) + "-0"}, nil
+	case "", "=", "==":
+		if n == 3 {
+			return []string{"=" + p.full()}, nil
+		}
+		if n == 0 {
+			return nil, nil
+		}
+		return []string{">=" + p.full(), "<" + p.next(n-1) + "-0"}, nil
+	case "!=", "!":
+		if n < 3 {
+			return nil, fmt.Errorf("'%s' needs a complete version", s)
+		}
+		return []string{"!=" + p.full()}, nil
+	case ">":
+		if n == 0 {
+			return []string{"<0.0.0-0"}, nil
+		}
+		if n < 3 {
+			return []string{">=" + p.next(n-1)}, nil
+		}
+		return []string{">" + p.full()}, nil
+	case ">=":
+		return []string{">=" + p.full()}, nil
+	case "<":
+		return []string{"<" + p.full()}, nil
+	case "<=":
+		if n == 0 {
+			return nil, nil
+		}
+		if n < 3 {
+			return []string{"<" + p.next(n-1) + "-0"}, nil
+		}
+		return []string{"<=" + p.full()}, nil
+	}
+	return nil, fmt.Errorf("unknown operator '%s'", op)
+}
+
+// hyphenRange translates `A - B`, a partial upper bound includes all its versions
+func hyphenRange(from, to string) ([]string, error) {
+	lower, err := parsePartial(from)
+	if err != nil {
+		return nil, err
+	}
+	upper, err := parsePartial(to)
+	if err != nil {
+		return nil, err
+	}
+	comparators := []string{">=" + lower.full()}
+	switch n := len(upper.numbers); {
+	case n == 0:
+	case n < 3:
+		comparators = append(comparators, "<"+upper.next(n-1)+"-0")
+	default:
+		comparators = append(comparators, "<="+upper.full())
+	}
+	return comparators, nil
+}
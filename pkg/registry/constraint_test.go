@@ -0,0 +1,57 @@
+package registry
+
+import (
+	"github.com/blang/semver"
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+)
+
+func TestParseConstraint(t *testing.T) {
+	versions := []string{"0.0.3", "0.0.4", "0.2.3", "0.2.9", "0.3.0", "1.0.0", "1.2.0", "1.2.3", "1.2.9", "1.3.0-beta.1", "1.3.0", "1.5.7", "1.6.0-rc.1", "1.6.0", "2.0.0-rc.1", "2.0.0", "2.3.4", "2.3.5"}
+	matching := func(r semver.Range) (matched []string) {
+		for _, v := range versions {
+			if r(semver.MustParse(v)) {
+				matched = append(matched, v)
+			}
+		}
+		return
+	}
+
+	// Prereleases of an excluded upper bound are excluded too, e.g. `2.0.0-rc.1` of `^1.2`
+	Convey("Test npm style constraints", t, func() {
+		cases := map[string][]string{
+			"^1.2.3":          {"1.2.3", "1.2.9", "1.3.0-beta.1", "1.3.0", "1.5.7", "1.6.0-rc.1", "1.6.0"},
+			"^1.2":            {"1.2.0", "1.2.3", "1.2.9", "1.3.0-beta.1", "1.3.0", "1.5.7", "1.6.0-rc.1", "1.6.0"},
+			"^0.2.3":          {"0.2.3", "0.2.9"},
+			"^0.0.3":          {"0.0.3"},
+			"^0.x":            {"0.0.3", "0.0.4", "0.2.3", "0.2.9", "0.3.0"},
+			"~1.2.3":          {"1.2.3", "1.2.9"},
+			"~1.2":            {"1.2.0", "1.2.3", "1.2.9"},
+			"~1":              {"1.0.0", "1.2.0", "1.2.3", "1.2.9", "1.3.0-beta.1", "1.3.0", "1.5.7", "1.6.0-rc.1", "1.6.0"},
+			"1.x":             {"1.0.0", "1.2.0", "1.2.3", "1.2.9", "1.3.0-beta.1", "1.3.0", "1.5.7", "1.6.0-rc.1", "1.6.0"},
+			"1.2.*":           {"1.2.0", "1.2.3", "1.2.9"},
+			"2":               {"2.0.0", "2.3.4", "2.3.5"},
+			"1.2 - 1.5":       {"1.2.0", "1.2.3", "1.2.9", "1.3.0-beta.1", "1.3.0", "1.5.7"},
+			"1.2.3 - 2.3.4":   {"1.2.3", "1.2.9", "1.3.0-beta.1", "1.3.0", "1.5.7", "1.6.0-rc.1", "1.6.0", "2.0.0-rc.1", "2.0.0", "2.3.4"},
+			">1.5":            {"1.6.0", "2.0.0-rc.1", "2.0.0", "2.3.4", "2.3.5"},
+			"<=1.2":           {"0.0.3", "0.0.4", "0.2.3", "0.2.9", "0.3.0", "1.0.0", "1.2.0", "1.2.3", "1.2.9"},
+			">= 2.3.4":        {"2.3.4", "2.3.5"},
+			"^0.0.3 || ~2.3":  {"0.0.3", "2.3.4", "2.3.5"},
+			"*":               versions,
+			">1.0.0 <1.2.9":   {"1.2.0", "1.2.3"},
+			">=1.2.0 !=1.2.3": {"1.2.0", "1.2.9", "1.3.0-beta.1", "1.3.0", "1.5.7", "1.6.0-rc.1", "1.6.0", "2.0.0-rc.1", "2.0.0", "2.3.4", "2.3.5"},
+		}
+		for constraint, expected := range cases {
+			r, err := ParseConstraint(constraint)
+			So(err, ShouldBeNil)
+			So(matching(r), ShouldResemble, expected)
+		}
+	})
+
+	Convey("Test invalid constraints", t, func() {
+		for _, constraint := range []string{"", "^1.a", "1.2.3.4", "=>1.2.3", "!=1.2", "1.2 ||", "1.2-rc.1"} {
+			_, err := ParseConstraint(constraint)
+			So(err, ShouldNotBeNil)
+		}
+	})
+}
@@ -0,0 +1,109 @@
+package registry
+
+import (
+	b64 "encoding/base64"
+	"encoding/json"
+	"fmt"
+	"k8s.io/kubernetes/pkg/api"
+	"strings"
+)
+
+// DockerHub is a normalized name of the Docker Hub registry
+const DockerHub = "docker.io"
+
+// Docker Hub is known by many names in docker configs and image names
+var dockerHubAliases = map[string]bool{
+	"default":                 true,
+	"docker.io":               true,
+	"index.docker.io":         true,
+	"registry-1.docker.io":    true,
+	"registry.hub.docker.com": true,
+}
+
+// DockerConfigJSON is a structure to unmarshall .dockerconfigjson data and docker `config.json` files
+type DockerConfigJSON struct {
+	Auths map[string]*Credentials `json:"auths"`
+	// CredHelpers are credential helper names by registry host
+	CredHelpers map[string]string `json:"credHelpers"`
+	// CredsStore is a credential helper for registries without their own helper
+	CredsStore string `json:"credsStore"`
+}
+
+// NormalizeHost returns a registry host for the docker config key,
+// e.g. `https://index.docker.io/v1/` becomes `docker.io`
+func NormalizeHost(name string) string {
+	host := strings.TrimSpace(name)
+	if i := strings.Index(host, "://"); i >= 0 {
+		host = host[i+3:]
+	}
+	if i := strings.Index(host, "/"); i >= 0 {
+		host = host[:i]
+	}
+	host = strings.ToLower(host)
+	if dockerHubAliases[host] {
+		return DockerHub
+	}
+	return host
+}
+
+// ParseDockerConfig returns credentials by registry host from `kubernetes.io/dockercfg`
+// or `kubernetes.io/dockerconfigjson` image pull secret
+func ParseDockerConfig(secret *api.Secret) (credentials map[string]*Credentials, err error) {
+	var entries map[string]*Credentials
+	if cfg, ok := secret.Data[api.DockerConfigJsonKey]; ok && (secret.Type == api.SecretTypeDockerConfigJson || len(secret.Data[api.DockerConfigKey]) == 0) {
+		config := new(DockerConfigJSON)
+		if err = json.Unmarshal(cfg, config); err != nil {
+			err = fmt.Errorf("cannot parse secret '%s': %s", secret.Name, err)
+			return
+		}
+		entries = config.Auths
+	} else if cfg, ok := secret.Data[api.DockerConfigKey]; ok {
+		if err = json.Unmarshal(cfg, &entries); err != nil {
+			err = fmt.Errorf("cannot parse secret '%s': %s", secret.Name, err)
+			return
+		}
+	} else {
+		err = fmt.Errorf("secret '%s' has no docker config", secret.Name)
+		return
+	}
+
+	credentials, err = normalizeAuths(entries)
+	if err != nil {
+		err = fmt.Errorf("%s in secret '%s'", err, secret.Name)
+	}
+	return
+}
+
+// normalizeAuths decodes docker config credentials and maps them by normalized registry host
+func normalizeAuths(entries map[string]*Credentials) (credentials map[string]*Credentials, err error) {
+	credentials = make(map[string]*Credentials)
+	for name, creds := range entries {
+		if creds == nil {
+			continue
+		}
+		if err = creds.decode(); err != nil {
+			err = fmt.Errorf("cannot decode credentials for '%s': %s", name, err)
+			return
+		}
+		credentials[NormalizeHost(name)] = creds
+	}
+	return
+}
+
+// decode fills username and password from base64 encoded `auth` field if they are not set
+func (c *Credentials) decode() error {
+	if c.Auth == "" || c.Username != "" || c.Password != "" {
+		return nil
+	}
+	auth, err := b64.StdEncoding.DecodeString(c.Auth)
+	if err != nil {
+		return err
+	}
+	parts := strings.SplitN(string(auth), ":", 2)
+	if len(parts) != 2 {
+		return fmt.Errorf("invalid auth, expect 'username:password'")
+	}
+	c.Username = parts[0]
+	c.Password = parts[1]
+	return nil
+}
@@ -0,0 +1,58 @@
+package registry
+
+import (
+	. "github.com/smartystreets/goconvey/convey"
+	"k8s.io/kubernetes/pkg/api"
+	"testing"
+)
+
+func TestParseDockerConfig(t *testing.T) {
+	Convey("Test docker config parsing", t, func() {
+		Convey("Parse kubernetes.io/dockercfg secret", func() {
+			secret := &api.Secret{
+				Type: api.SecretTypeDockercfg,
+				Data: map[string][]byte{
+					api.DockerConfigKey: []byte(`{"registry.example.com": {"username": "user", "password": "secret"}}`),
+				},
+			}
+			credentials, err := ParseDockerConfig(secret)
+			So(err, ShouldBeNil)
+			So(credentials, ShouldContainKey, "registry.example.com")
+			So(credentials["registry.example.com"].Username, ShouldEqual, "user")
+			So(credentials["registry.example.com"].Password, ShouldEqual, "secret")
+		})
+
+		Convey("Parse kubernetes.io/dockerconfigjson secret with auth field", func() {
+			secret := &api.Secret{
+				Type: api.SecretTypeDockerConfigJson,
+				Data: map[string][]byte{
+					// "dXNlcjpzZWNyZXQ6d2l0aDpjb2xvbnM=" is "user:secret:with:colons"
+					api.DockerConfigJsonKey: []byte(`{"auths": {
+						"https://index.docker.io/v1/": {"auth": "dXNlcjpzZWNyZXQ6d2l0aDpjb2xvbnM="},
+						"localhost:5000": {"username": "local", "password": "pass"}
+					}}`),
+				},
+			}
+			credentials, err := ParseDockerConfig(secret)
+			So(err, ShouldBeNil)
+			So(credentials, ShouldContainKey, DockerHub)
+			So(credentials[DockerHub].Username, ShouldEqual, "user")
+			So(credentials[DockerHub].Password, ShouldEqual, "secret:with:colons")
+			So(credentials["localhost:5000"].Username, ShouldEqual, "local")
+		})
+
+		Convey("Expect error for a secret without docker config", func() {
+			secret := &api.Secret{Data: map[string][]byte{"token": []byte("abc")}}
+			_, err := ParseDockerConfig(secret)
+			So(err, ShouldNotBeNil)
+		})
+	})
+
+	Convey("Test registry host normalization", t, func() {
+		So(NormalizeHost("https://index.docker.io/v1/"), ShouldEqual, DockerHub)
+		So(NormalizeHost("default"), ShouldEqual, DockerHub)
+		So(NormalizeHost("registry-1.docker.io"), ShouldEqual, DockerHub)
+		So(NormalizeHost("https://Registry.Example.com"), ShouldEqual, "registry.example.com")
+		So(NormalizeHost("localhost:5000"), ShouldEqual, "localhost:5000")
+	})
+}
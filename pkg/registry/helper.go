@@ -0,0 +1,115 @@
+package registry
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"os/exec"
+	"strings"
+	"sync"
+)
+
+// Username a credential helper returns for an identity token
+const identityTokenUsername = "<token>"
+
+// CredentialHelpers gets credentials with docker credential helpers, `docker-credential-<helper> get`.
+// A helper is chosen by the registry host from `Helpers`, then `credential_helper` of the registry
+// config, then `Default`. Results are cached, so a helper runs once per host for the provider lifetime.
+// A missing or failing helper has no credentials, so other providers and anonymous access are tried.
+type CredentialHelpers struct {
+	// Helpers are helper names by normalized registry host
+	Helpers map[string]string
+	Default string
+
+	mu    sync.Mutex
+	cache map[string]*Credentials
+}
+
+// helperResponse is an output of the credential helper `get` command
+type helperResponse struct {
+	ServerURL string `json:"ServerURL"`
+	Username  string `json:"Username"`
+	Secret    string `json:"Secret"`
+}
+
+// GetCredentials implements CredentialProvider
+func (h *CredentialHelpers) GetCredentials(host string) (credentials *Credentials, err error) {
+	host = NormalizeHost(host)
+	helper := h.helper(host)
+	if helper == "" {
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	credentials, ok := h.cache[host]
+	if ok {
+		return
+	}
+	credentials, err = runCredentialHelper(helper, host)
+	if err != nil {
+		// Cache the failure as well, not to run a broken helper for every container
+		log.Warnf("registry=%s %s, continue without its credentials", host, err)
+		credentials, err = nil, nil
+	}
+	if h.cache == nil {
+		h.cache = make(map[string]*Credentials)
+	}
+	h.cache[host] = credentials
+	return
+}
+
+func (h *CredentialHelpers) String() string {
+	return "credential helpers"
+}
+
+func (h *CredentialHelpers) helper(host string) string {
+	if helper := h.Helpers[host]; helper != "" {
+		return helper
+	}
+	if helper := GetConfig(host).CredentialHelper; helper != "" {
+		return helper
+	}
+	return h.Default
+}
+
+// runCredentialHelper runs the helper `get` command for the registry host.
+// Credentials are nil if the helper has none for the host.
+func runCredentialHelper(helper, host string) (credentials *Credentials, err error) {
+	// Docker stores Docker Hub credentials by the v1 index address
+	serverURL := host
+	if host == DockerHub {
+		serverURL = "https://index.docker.io/v1/"
+	}
+
+	cmd := exec.Command("docker-credential-"+helper, "get")
+	cmd.Stdin = strings.NewReader(serverURL)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err = cmd.Run(); err != nil {
+		if e, ok := err.(*exec.Error); ok && e.Err == exec.ErrNotFound {
+			err = fmt.Errorf("credential helper '%s' is not installed", helper)
+			return
+		}
+		message := strings.TrimSpace(stdout.String() + " " + stderr.String())
+		if strings.Contains(message, "credentials not found") {
+			err = nil
+			return
+		}
+		err = fmt.Errorf("credential helper '%s' failed for '%s': %s %s", helper, host, err, message)
+		return
+	}
+
+	res := new(helperResponse)
+	if err = json.Unmarshal(stdout.Bytes(), res); err != nil {
+		err = fmt.Errorf("invalid output of credential helper '%s': %s", helper, err)
+		return
+	}
+	credentials = &Credentials{Username: res.Username, Password: res.Secret}
+	if res.Username == identityTokenUsername {
+		credentials = &Credentials{IdentityToken: res.Secret}
+	}
+	return
+}
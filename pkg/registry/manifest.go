@@ -0,0 +1,156 @@
+package registry
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// MaxResolveLookups limits a number of version tag manifests to check while resolving a floating tag
+var MaxResolveLookups = 50
+
+// Manifest media types to accept while resolving a tag digest
+const (
+	MediaTypeDockerManifest     = "application/vnd.docker.distribution.manifest.v2+json"
+	MediaTypeDockerManifestList = "application/vnd.docker.distribution.manifest.list.v2+json"
+	MediaTypeOCIManifest        = "application/vnd.oci.image.manifest.v1+json"
+	MediaTypeOCIIndex           = "application/vnd.oci.image.index.v1+json"
+)
+
+// manifestAccept lists manifest types in order of preference. Manifest lists and indexes go first,
+// so a multi-platform image resolves to the same digest `docker pull` does.
+var manifestAccept = []string{
+	MediaTypeDockerManifestList,
+	MediaTypeOCIIndex,
+	MediaTypeDockerManifest,
+	MediaTypeOCIManifest,
+}
+
+// GetDigest returns the manifest digest for the tag of the image repository.
+// It makes HEAD request first and falls back to GET if a registry does not
+// report `Docker-Content-Digest` header, then the digest is calculated from the manifest.
+// Digests are cached for `CacheTTL`.
+func (r *Registry) GetDigest(repo, tag string) (digest string, err error) {
+	key := repo + ":" + tag
+	r.mu.Lock()
+	cached, ok := r.digests[key]
+	r.mu.Unlock()
+	if ok && !r.expired(cached.fetched) {
+		digest = cached.digest
+		return
+	}
+
+	digest, err = r.getDigest(repo, tag)
+	if err != nil {
+		return
+	}
+	r.mu.Lock()
+	if r.digests == nil {
+		r.digests = make(map[string]*digestEntry)
+	}
+	r.digests[key] = &digestEntry{digest: digest, fetched: time.Now()}
+	r.mu.Unlock()
+	return
+}
+
+func (r *Registry) getDigest(repo, tag string) (digest string, err error) {
+	header := http.Header{"Accept": manifestAccept}
+
+	res, err := r.Do("HEAD", header, "/v2/%s/manifests/%s", repo, tag)
+	if err != nil {
+		return
+	}
+	res.Body.Close()
+	if res.StatusCode == http.StatusOK {
+		if digest = res.Header.Get("Docker-Content-Digest"); digest != "" {
+			return
+		}
+	} else if res.StatusCode == http.StatusNotFound {
+		err = fmt.Errorf("manifest '%s:%s' not found", repo, tag)
+		return
+	}
+
+	res, err = r.Do("GET", header, "/v2/%s/manifests/%s", repo, tag)
+	if err != nil {
+		return
+	}
+	defer res.Body.Close()
+	if res.StatusCode >= 400 {
+		err = fmt.Errorf("cannot get manifest '%s:%s': %s", repo, tag, res.Status)
+		return
+	}
+	if digest = res.Header.Get("Docker-Content-Digest"); digest != "" {
+		return
+	}
+	if mediaType := res.Header.Get("Content-Type"); strings.Contains(mediaType, "prettyjws") {
+		// Digest of signed schema 1 manifest could not be calculated from the response body
+		err = fmt.Errorf("registry did not report digest for schema 1 manifest '%s:%s'", repo, tag)
+		return
+	}
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return
+	}
+	sum := sha256.Sum256(body)
+	digest = "sha256:" + hex.EncodeToString(sum[:])
+	return
+}
+
+// ResolveTag returns the greatest version tag which points to the same manifest as the floating tag,
+// e.g. `1.8.3` for `stable`. The version's `Digest` is set to the floating tag digest.
+// Version tags are checked from the greatest one, so usually only a few manifests are requested.
+// A version tag which manifest could not be found is skipped, an unavailable registry is an error.
+func (r *Repository) ResolveTag(floating string, filter VersionRange) (version *Version, err error) {
+	digest, err := r.GetDigest(floating)
+	if err != nil {
+		return
+	}
+	tags, err := r.GetTags()
+	if err != nil {
+		return
+	}
+
+	var candidates Versions
+	for _, tag := range tags {
+		if tag == floating {
+			continue
+		}
+		v, e := r.scheme().Parse(tag)
+		if e != nil {
+			continue
+		}
+		if filter != nil && !filter(v) {
+			continue
+		}
+		candidates = append(candidates, v)
+	}
+	sort.Sort(sort.Reverse(candidates))
+
+	for i, v := range candidates {
+		if MaxResolveLookups > 0 && i >= MaxResolveLookups {
+			break
+		}
+		d, e := r.GetDigest(v.Tag)
+		if IsUnavailable(e) {
+			err = e
+			return
+		} else if e != nil {
+			// e.g. the tag is deleted after tags were listed, other tags could still match
+			log.Warnf("could not get digest of '%s:%s' to resolve '%s': %s", r.FullName(), v.Tag, floating, e)
+			continue
+		}
+		if d == digest {
+			v.Digest = digest
+			version = v
+			return
+		}
+	}
+	err = fmt.Errorf("there is no version tag for '%s:%s' with digest %s", r.Name, floating, digest)
+	return
+}
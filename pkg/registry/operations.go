@@ -1,30 +1,56 @@
 package registry
 
 import (
-	b64 "encoding/base64"
 	"encoding/json"
 	"fmt"
 	"github.com/blang/semver"
-	ext "k8s.io/kubernetes/pkg/apis/extensions"
+	"github.com/sabakaio/k8s-updater/pkg/reference"
+	"k8s.io/kubernetes/pkg/api"
 	client "k8s.io/kubernetes/pkg/client/unversioned"
 	"net/http"
+	"net/url"
 	"strings"
+	"time"
 )
 
-// NewRegistry create a new registry with given name and credentials and configure http client
+// NewRegistry create a new registry with given name and credentials and configure http client.
+// The name is normalized with `NormalizeHost`.
 func NewRegistry(name string, credentials *Credentials) (r *Registry, err error) {
-	transport := &BasicAuthTransport{
-		Username: credentials.Username,
-		Password: credentials.Password,
+	name = NormalizeHost(name)
+	if credentials == nil {
+		credentials = new(Credentials)
+	}
+	if err = credentials.decode(); err != nil {
+		return
+	}
+	cfg := GetConfig(name)
+	base, err := cfg.NewTransport()
+	if err != nil {
+		err = fmt.Errorf("cannot configure registry '%s': %s", name, err)
+		return
+	}
+	transport := &AuthTransport{
+		Username:      credentials.Username,
+		Password:      credentials.Password,
+		IdentityToken: credentials.IdentityToken,
+		UserAgent:     cfg.GetUserAgent(),
+		Transport:     getRetryTransport(name, cfg, base),
+	}
+	c := &http.Client{Transport: transport, Timeout: cfg.GetTimeout()}
+	r = &Registry{
+		Name:        name,
+		Scheme:      cfg.GetScheme(),
+		PageSize:    DefaultPageSize,
+		MaxPages:    DefaultMaxPages,
+		credentials: credentials,
+		client:      c,
 	}
-	c := &http.Client{Transport: transport}
-	r = &Registry{Name: name, credentials: credentials, client: c}
 	return
 }
 
 // GetHost return the registry's host for API requests
 func (r *Registry) GetHost() string {
-	if r.Name == "default" {
+	if r.Name == DockerHub || r.Name == "default" {
 		return "index.docker.io"
 	}
 	return r.Name
@@ -32,15 +58,127 @@ func (r *Registry) GetHost() string {
 
 // Get makes GET API call for given path with auth headers
 func (r *Registry) Get(pathTemplate string, args ...interface{}) (response *http.Response, err error) {
+	return r.Do("GET", nil, pathTemplate, args...)
+}
+
+// Do makes API call with given method, headers and path with auth headers
+func (r *Registry) Do(method string, header http.Header, pathTemplate string, args ...interface{}) (response *http.Response, err error) {
 	path := fmt.Sprintf(pathTemplate, args...)
-	url := fmt.Sprintf("https://%s%s", r.GetHost(), path)
-	response, err = r.client.Get(url)
+	scheme := r.Scheme
+	if scheme == "" {
+		scheme = "https"
+	}
+	url := fmt.Sprintf("%s://%s%s", scheme, r.GetHost(), path)
+	req, err := http.NewRequest(method, url, nil)
+	if err != nil {
+		return
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	response, err = r.client.Do(req)
 	return
 }
 
-// GetTags returns list of tags for the image repository
+// GetTags returns list of tags for the image repository. It walks all pages of the tag list
+// following `Link` headers. If a page fails, the tags got so far are returned with `PartialTagsError`.
+// Tag lists are cached for `CacheTTL`, and revalidated with `If-None-Match` if `Revalidate` is set.
 func (r *Registry) GetTags(repo string) (tags []string, err error) {
-	res, err := r.Get("/v2/%s/tags/list", repo)
+	r.mu.Lock()
+	cached := r.tags[repo]
+	r.mu.Unlock()
+	if cached != nil && !r.expired(cached.fetched) {
+		return cached.copyTags(), nil
+	}
+	// Revalidate single page lists only, a tag could be added to any page of a long list
+	if cached != nil && r.Revalidate && cached.etag != "" && cached.pages == 1 {
+		if r.tagsNotModified(repo, cached.etag) {
+			r.mu.Lock()
+			cached.fetched = time.Now()
+			r.mu.Unlock()
+			return cached.copyTags(), nil
+		}
+	}
+
+	entry, err := r.fetchTags(repo)
+	if err != nil {
+		if entry != nil {
+			tags = entry.tags
+		}
+		return
+	}
+	r.mu.Lock()
+	if r.tags == nil {
+		r.tags = make(map[string]*tagsEntry)
+	}
+	r.tags[repo] = entry
+	r.mu.Unlock()
+	return entry.copyTags(), nil
+}
+
+// fetchTags gets all pages of the tag list
+func (r *Registry) fetchTags(repo string) (entry *tagsEntry, err error) {
+	path := fmt.Sprintf("/v2/%s/tags/list", repo)
+	if r.PageSize > 0 {
+		path += fmt.Sprintf("?n=%d", r.PageSize)
+	}
+	entry = &tagsEntry{fetched: time.Now()}
+	seen := make(map[string]bool)
+	for path != "" {
+		if r.MaxPages > 0 && entry.pages >= r.MaxPages {
+			err = fmt.Errorf("page limit %d reached", r.MaxPages)
+			break
+		}
+		page, e := r.getTagsPage(repo, path, "")
+		if e != nil {
+			err = e
+			break
+		}
+		if entry.pages == 0 {
+			entry.etag = page.etag
+		}
+		entry.pages++
+
+		added := 0
+		for _, tag := range page.tags {
+			if !seen[tag] {
+				seen[tag] = true
+				entry.tags = append(entry.tags, tag)
+				added++
+			}
+		}
+		// Some registries do not send `Link` header, so try to request the next page
+		// with `last` parameter if the page is full
+		next := page.next
+		if next == "" && r.PageSize > 0 && len(page.tags) >= r.PageSize && added > 0 {
+			next = fmt.Sprintf("/v2/%s/tags/list?n=%d&last=%s", repo, r.PageSize, url.QueryEscape(page.tags[len(page.tags)-1]))
+		}
+		path = next
+	}
+	if err != nil && entry.pages > 0 {
+		err = &PartialTagsError{Repo: repo, Pages: entry.pages, Tags: len(entry.tags), Err: err}
+	}
+	return
+}
+
+// tagsNotModified checks if a single page tag list was not changed since it got the ETag
+func (r *Registry) tagsNotModified(repo, etag string) bool {
+	path := fmt.Sprintf("/v2/%s/tags/list", repo)
+	if r.PageSize > 0 {
+		path += fmt.Sprintf("?n=%d", r.PageSize)
+	}
+	page, err := r.getTagsPage(repo, path, etag)
+	return err == nil && page.notModified
+}
+
+// getTagsPage gets one page of the tag list with the path of the next page if any.
+// `If-None-Match` header is sent if the ETag is given.
+func (r *Registry) getTagsPage(repo, path, etag string) (page *tagsPage, err error) {
+	var header http.Header
+	if etag != "" {
+		header = http.Header{"If-None-Match": []string{etag}}
+	}
+	res, err := r.Do("GET", header, "%s", path)
 	if res != nil {
 		defer res.Body.Close()
 	}
@@ -48,58 +186,75 @@ func (r *Registry) GetTags(repo string) (tags []string, err error) {
 	if err != nil {
 		return
 	}
+	page = &tagsPage{etag: res.Header.Get("ETag")}
+	if res.StatusCode == http.StatusNotModified {
+		page.notModified = true
+		return
+	}
 	if res.StatusCode >= 400 {
+		page = nil
 		err = fmt.Errorf("cannot get tag list for '%s': %s", repo, res.Status)
 		return
 	}
 
 	target := new(TagsList)
-	err = json.NewDecoder(res.Body).Decode(target)
-	if err != nil {
+	if err = json.NewDecoder(res.Body).Decode(target); err != nil {
+		page = nil
 		return
 	}
-	tags = target.Tags
+	page.tags = target.Tags
+	if page.next, err = nextPageLink(res); err != nil {
+		page = nil
+	}
 	return
 }
 
-// GetRegistries returns list of registries based on deployment's image pull secrets
-func GetRegistries(k *client.Client, deployment *ext.Deployment) (registries *RegistryList, err error) {
-	registries = new(RegistryList)
-	for _, s := range deployment.Spec.Template.Spec.ImagePullSecrets {
-		// Get image pull secret, ...
-		secret, e := k.Secrets(deployment.Namespace).Get(s.Name)
-		if e != nil {
-			err = e
-			return
-		}
-		// ... decode from base64, ...
-		cfg_b64 := b64.StdEncoding.EncodeToString(secret.Data[".dockercfg"])
-		cfg, e := b64.StdEncoding.DecodeString(cfg_b64)
-		if e != nil {
-			err = e
-			return
-		}
-		// ... and unmarshal into map of credentials structs
-		var credentialsMap map[string]*Credentials
-		err = json.Unmarshal(cfg, &credentialsMap)
-		if err != nil {
-			return
-		}
+// expired checks if a cache entry fetched at the time is expired
+func (r *Registry) expired(fetched time.Time) bool {
+	return r.CacheTTL > 0 && time.Since(fetched) > r.CacheTTL
+}
 
-		for name, creds := range credentialsMap {
-			if r, e := NewRegistry(name, creds); e == nil {
-				registries.Items = append(registries.Items, r)
-			} else {
-				err = e
+// nextPageLink returns request URI of the `rel="next"` RFC 5988 link of the response
+func nextPageLink(res *http.Response) (next string, err error) {
+	for _, header := range res.Header[http.CanonicalHeaderKey("Link")] {
+		for _, link := range strings.Split(header, ",") {
+			parts := strings.Split(link, ";")
+			target := strings.TrimSpace(parts[0])
+			if !strings.HasPrefix(target, "<") || !strings.HasSuffix(target, ">") {
+				continue
+			}
+			isNext := false
+			for _, param := range parts[1:] {
+				param = strings.Replace(strings.TrimSpace(param), " ", "", -1)
+				if param == `rel="next"` || param == "rel=next" {
+					isNext = true
+				}
+			}
+			if !isNext {
+				continue
+			}
+			u, e := url.Parse(strings.Trim(target, "<>"))
+			if e != nil {
+				err = fmt.Errorf("invalid next page link '%s': %s", target, e)
 				return
 			}
+			// A link could be relative to the request or absolute
+			next = res.Request.URL.ResolveReference(u).RequestURI()
+			return
 		}
 	}
 	return
 }
 
+// GetRegistries returns list of registries based on image pull secrets of the pod template and its service account.
+// Secrets and registries are shared with other workloads through `DefaultCache`.
+func GetRegistries(k *client.Client, namespace string, template *api.PodTemplateSpec) (registries *RegistryList, err error) {
+	return DefaultCache.GetRegistries(k, namespace, template)
+}
+
 // Get returns a registry with the given name from the list
 func (list *RegistryList) Get(name string) (r *Registry, err error) {
+	name = NormalizeHost(name)
 	for _, item := range list.Items {
 		if item.Name == name {
 			r = item
@@ -110,23 +265,69 @@ func (list *RegistryList) Get(name string) (r *Registry, err error) {
 	return
 }
 
-// NewRepository return a new Repository for given image name
-func NewRepository(image string, registry *Registry) *Repository {
-	// Cut off image version
-	image = strings.SplitN(image, ":", 2)[0]
-	// Cut off custom registry domain from image name
-	if strings.HasPrefix(image, registry.Name+"/") {
-		image = strings.SplitN(image, "/", 2)[1]
+// add appends the registry to the list unless there is one with the same name already
+func (list *RegistryList) add(r *Registry, from string) {
+	if _, err := list.Get(r.Name); err == nil {
+		return
+	}
+	list.Items = append(list.Items, r)
+	if list.sources == nil {
+		list.sources = make(map[string]string)
+	}
+	list.sources[r.Name] = from
+}
+
+// Lookup returns a registry for the image domain with credentials from the list image pull secrets,
+// or from `DefaultProviders`. Anonymous access is used if there are no credentials for the registry.
+// `from` describes where credentials came from.
+func (list *RegistryList) Lookup(domain string) (r *Registry, from string, err error) {
+	if r, err = list.Get(domain); err == nil {
+		from = list.sources[r.Name]
+		if from == "" {
+			from = "image pull secret"
+		}
+		return
+	}
+	credentials, provider := DefaultProviders.Find(domain)
+	from = "anonymous access"
+	if provider != nil {
+		from = provider.String()
 	}
+	r, err = GetRegistry(domain, credentials)
+	return
+}
+
+// NewRepository return a new Repository for given image reference. Tags are listed with the registry API.
+func NewRepository(ref *reference.Reference, registry *Registry) *Repository {
 	return &Repository{
-		Name:     image,
+		Name:     ref.Path,
+		Domain:   ref.Domain,
 		Registry: registry,
+		Source:   RegistrySource{},
+	}
+}
+
+// FullName returns the repository name with the registry domain, e.g. `docker.io/library/nginx`
+func (r *Repository) FullName() string {
+	return r.Domain + "/" + r.Name
+}
+
+// GetTags returns list of tags from the repository tag source
+func (r *Repository) GetTags() ([]string, error) {
+	return r.source().GetTags(r)
+}
+
+func (r *Repository) source() TagSource {
+	if r.Source == nil {
+		return RegistrySource{}
 	}
+	return r.Source
 }
 
-// GetLatestVersion returns the latest image version based on tag
-func (r *Repository) GetLatestVersion(filter semver.Range) (version *Version, err error) {
-	tags, err := r.Registry.GetTags(r.Name)
+// GetLatestVersion returns the latest image version based on tag. Tags are parsed with the repository `Scheme`,
+// tags which are not versions of the scheme are skipped.
+func (r *Repository) GetLatestVersion(filter VersionRange) (version *Version, err error) {
+	tags, err := r.GetTags()
 	if err != nil {
 		return
 	}
@@ -134,29 +335,68 @@ func (r *Repository) GetLatestVersion(filter semver.Range) (version *Version, er
 		err = fmt.Errorf("there is no image tags for '%s'", r.Name)
 	}
 	for _, tag := range tags {
-		v, e := NewVersion(tag)
+		v, e := r.scheme().Parse(tag)
 		if e != nil {
 			continue
 		}
 		// Skip versions which are out of versions range filter
-		if filter != nil && !filter(v.Semver) {
+		if filter != nil && !filter(v) {
 			continue
 		}
 		// Get greater version
-		if version == nil || v.Semver.GT(version.Semver) {
+		if version == nil || v.GT(version) {
 			version = v
 		}
 	}
 	return
 }
 
+// ParseVersion parses the tag with the repository version scheme
+func (r *Repository) ParseVersion(tag string) (*Version, error) {
+	return r.scheme().Parse(tag)
+}
+
+func (r *Repository) scheme() VersionScheme {
+	if r.Scheme == nil {
+		return SemverScheme{}
+	}
+	return r.Scheme
+}
+
+// GetDigest returns the manifest digest of the image tag in the repository
+func (r *Repository) GetDigest(tag string) (string, error) {
+	return r.source().GetDigest(r, tag)
+}
+
 // NewVersion return association for image tag and its semver
 func NewVersion(tag string) (version *Version, err error) {
 	v, err := semver.ParseTolerant(tag)
-	version = &Version{Tag: tag, Semver: v}
+	version = &Version{Tag: tag, Semver: v, Scheme: SemverScheme{}}
 	return
 }
 
+// Compare returns -1, 0 or 1 if the version is less than, equal to or greater than the other one
+func (v *Version) Compare(other *Version) int {
+	scheme := v.Scheme
+	if scheme == nil {
+		scheme = SemverScheme{}
+	}
+	return scheme.Compare(v, other)
+}
+
+// GT checks if the version is greater than the other one
+func (v *Version) GT(other *Version) bool {
+	return v.Compare(other) > 0
+}
+
+func (v *Version) isSemver() bool {
+	_, ok := v.Scheme.(SemverScheme)
+	return v.Scheme == nil || ok
+}
+
 func (v *Version) String() string {
+	if v.Digest != "" {
+		return v.Tag + "@" + v.Digest
+	}
 	return v.Tag
 }
@@ -0,0 +1,193 @@
+package registry
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"github.com/blang/semver"
+	. "github.com/smartystreets/goconvey/convey"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// newTestRegistry returns a registry for the test server
+func newTestRegistry(server *httptest.Server) *Registry {
+	transport := &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}
+	return &Registry{
+		Name:     strings.TrimPrefix(server.URL, "https://"),
+		PageSize: DefaultPageSize,
+		MaxPages: DefaultMaxPages,
+		client:   &http.Client{Transport: &AuthTransport{Transport: transport}},
+	}
+}
+
+func TestGetTags(t *testing.T) {
+	var allTags []string
+	for i := 0; i < 25; i++ {
+		allTags = append(allTags, fmt.Sprintf("1.0.%d", i))
+	}
+	failAfter := -1
+	withLink := true
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n, _ := strconv.Atoi(r.URL.Query().Get("n"))
+		start := 0
+		if last := r.URL.Query().Get("last"); last != "" {
+			for i, tag := range allTags {
+				if tag == last {
+					start = i + 1
+				}
+			}
+		}
+		if failAfter >= 0 && start >= failAfter {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		end := start + n
+		if n == 0 || end > len(allTags) {
+			end = len(allTags)
+		}
+		if withLink && end < len(allTags) {
+			w.Header().Set("Link", fmt.Sprintf(`</v2/app/tags/list?n=%d&last=%s>; rel="next"`, n, allTags[end-1]))
+		}
+		json.NewEncoder(w).Encode(&TagsList{Name: "app", Tags: allTags[start:end]})
+	}))
+	defer server.Close()
+
+	Convey("Test tag list pagination", t, func() {
+		r := newTestRegistry(server)
+		r.PageSize = 10
+		failAfter = -1
+		withLink = true
+
+		Convey("All pages are fetched following the link header", func() {
+			tags, err := r.GetTags("app")
+			So(err, ShouldBeNil)
+			So(tags, ShouldResemble, allTags)
+		})
+
+		Convey("All pages are fetched without the link header", func() {
+			withLink = false
+			tags, err := r.GetTags("app")
+			So(err, ShouldBeNil)
+			So(tags, ShouldResemble, allTags)
+		})
+
+		Convey("Partial results are reported if a page fails", func() {
+			failAfter = 20
+			tags, err := r.GetTags("app")
+			So(err, ShouldHaveSameTypeAs, &PartialTagsError{})
+			So(err.(*PartialTagsError).Pages, ShouldEqual, 2)
+			So(tags, ShouldResemble, allTags[:20])
+		})
+
+		Convey("Number of pages is limited", func() {
+			r.MaxPages = 2
+			tags, err := r.GetTags("app")
+			So(err, ShouldNotBeNil)
+			So(tags, ShouldHaveLength, 20)
+		})
+	})
+}
+
+func TestGetDigest(t *testing.T) {
+	manifest := []byte(`{"schemaVersion": 2, "mediaType": "application/vnd.oci.image.manifest.v1+json"}`)
+	reportDigest := true
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v2/app/manifests/1.0.0" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if !strings.Contains(strings.Join(r.Header["Accept"], ","), MediaTypeOCIManifest) {
+			w.WriteHeader(http.StatusNotAcceptable)
+			return
+		}
+		w.Header().Set("Content-Type", MediaTypeOCIManifest)
+		if reportDigest {
+			w.Header().Set("Docker-Content-Digest", "sha256:reported")
+		}
+		if r.Method == "GET" {
+			w.Write(manifest)
+		}
+	}))
+	defer server.Close()
+
+	Convey("Test manifest digest resolution", t, func() {
+		r := newTestRegistry(server)
+
+		Convey("Digest is taken from the response header", func() {
+			reportDigest = true
+			digest, err := r.GetDigest("app", "1.0.0")
+			So(err, ShouldBeNil)
+			So(digest, ShouldEqual, "sha256:reported")
+		})
+
+		Convey("Digest is calculated if registry does not report it", func() {
+			reportDigest = false
+			digest, err := r.GetDigest("app", "1.0.0")
+			So(err, ShouldBeNil)
+			So(digest, ShouldEqual, fmt.Sprintf("sha256:%x", sha256.Sum256(manifest)))
+		})
+
+		Convey("Expect error for unknown tag", func() {
+			_, err := r.GetDigest("app", "2.0.0")
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+func TestResolveTag(t *testing.T) {
+	digests := map[string]string{
+		"stable": "sha256:stable",
+		"1":      "sha256:stable",
+		"1.8":    "sha256:stable",
+		"1.8.3":  "sha256:stable",
+		"1.8.2":  "sha256:old",
+		"1.9.0":  "sha256:next",
+		"latest": "sha256:next",
+	}
+	// `1.9.1` is deleted after tags are listed, its manifest is not found
+	manifestRequests := 0
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/v2/app/tags/list" {
+			tags := []string{"1.9.1"}
+			for tag := range digests {
+				tags = append(tags, tag)
+			}
+			json.NewEncoder(w).Encode(&TagsList{Name: "app", Tags: tags})
+			return
+		}
+		digest, ok := digests[strings.TrimPrefix(r.URL.Path, "/v2/app/manifests/")]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		manifestRequests++
+		w.Header().Set("Docker-Content-Digest", digest)
+	}))
+	defer server.Close()
+
+	Convey("Test floating tag resolution", t, func() {
+		repo := &Repository{Name: "app", Registry: newTestRegistry(server)}
+
+		version, err := repo.ResolveTag("stable", nil)
+		So(err, ShouldBeNil)
+		So(version.Tag, ShouldEqual, "1.8.3")
+		So(version.Digest, ShouldEqual, "sha256:stable")
+		// `stable`, `1.9.0` and `1.8.3` manifests are checked only, missing `1.9.1` is skipped
+		So(manifestRequests, ShouldEqual, 3)
+
+		// Digests are cached
+		version, err = repo.ResolveTag("stable", nil)
+		So(err, ShouldBeNil)
+		So(version.Tag, ShouldEqual, "1.8.3")
+		So(manifestRequests, ShouldEqual, 3)
+
+		// No version tag for the floating tag in the range
+		_, err = repo.ResolveTag("latest", SemverRange(semver.MustParseRange("<1.9.0")))
+		So(err, ShouldNotBeNil)
+	})
+}
@@ -0,0 +1,165 @@
+package registry
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Release channels from the most to the least stable
+const (
+	ChannelStable = "stable"
+	ChannelRC     = "rc"
+	ChannelBeta   = "beta"
+	ChannelAlpha  = "alpha"
+)
+
+var channelRanks = map[string]int{
+	ChannelStable: 0,
+	ChannelRC:     1,
+	ChannelBeta:   2,
+	ChannelAlpha:  3,
+}
+
+var (
+	// DefaultChannel is a release channel for containers without `autoupdate_channel_<container>` annotation
+	DefaultChannel = ChannelStable
+	// DefaultPreferFinal makes containers on prerelease channels update to a final release newer than the current version
+	// first if there is one, even if its core version differs from the latest prerelease
+	DefaultPreferFinal = true
+)
+
+// Channel returns the release channel of the version by the first prerelease identifier:
+// `rc` for `rc.1` or `pre`, `beta` for `beta.2` or `b2`, and `alpha` for `alpha` or any unknown one
+// like `dev` or `nightly`. Final releases and versions of schemes without prereleases are `stable`.
+func (v *Version) Channel() string {
+	if !v.IsPrerelease() {
+		return ChannelStable
+	}
+	pre := v.Semver.Pre[0]
+	if pre.IsNum {
+		return ChannelAlpha
+	}
+	switch strings.TrimRight(strings.ToLower(pre.VersionStr), "0123456789-") {
+	case "rc", "pre", "preview", "cr":
+		return ChannelRC
+	case "beta", "b":
+		return ChannelBeta
+	}
+	return ChannelAlpha
+}
+
+// IsPrerelease checks if the version is a semver prerelease
+func (v *Version) IsPrerelease() bool {
+	return len(v.Semver.Pre) > 0
+}
+
+// ChannelRange returns a range of versions the channel allows: final releases for `stable`,
+// final releases and release candidates for `rc` and so on.
+func ChannelRange(channel string) (VersionRange, error) {
+	if channel == "" {
+		channel = DefaultChannel
+	}
+	rank, ok := channelRanks[channel]
+	if !ok {
+		return nil, fmt.Errorf("unknown release channel '%s'", channel)
+	}
+	return func(v *Version) bool {
+		return channelRanks[v.Channel()] <= rank
+	}, nil
+}
+
+// FinalRelease is a range of versions which are not prereleases
+func FinalRelease(v *Version) bool {
+	return !v.IsPrerelease()
+}
+
+// AllOf returns a range of versions which are in all the ranges, nil ranges are skipped
+func AllOf(ranges ...VersionRange) VersionRange {
+	return func(v *Version) bool {
+		for _, r := range ranges {
+			if r != nil && !r(v) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// SeriesRange allows versions of the same series as the current version, e.g. `build-` build numbers
+// for `build-4812` but not `pr-9999` or `sha-abc12345`. Versions without prefix are allowed if the current version is nil.
+func SeriesRange(current *Version) VersionRange {
+	prefix := ""
+	if current != nil {
+		prefix = current.Prefix
+	}
+	return func(v *Version) bool {
+		return v.Prefix == prefix
+	}
+}
+
+// Update policies relative to the current version
+const (
+	// PolicyPatch allows updates with the same major and minor version
+	PolicyPatch = "patch"
+	// PolicyMinor allows updates with the same major version
+	PolicyMinor = "minor"
+	// PolicyMajor allows all updates
+	PolicyMajor = "major"
+	// PolicyNone reports available updates only
+	PolicyNone = "none"
+)
+
+// DefaultPolicy is an update policy for containers without `autoupdate_policy_<container>` annotation
+var DefaultPolicy = PolicyMajor
+
+// ValidatePolicy checks if the update policy is known
+func ValidatePolicy(policy string) error {
+	switch policy {
+	case PolicyPatch, PolicyMinor, PolicyMajor, PolicyNone:
+		return nil
+	}
+	return fmt.Errorf("unknown update policy '%s'", policy)
+}
+
+// PolicyRange returns a range of versions the update policy allows relative to the current version.
+// Leading parts of versions are compared: major and minor of semver, year and month of calver.
+// `major` and `none` allow all versions.
+func PolicyRange(policy string, current *Version) (VersionRange, error) {
+	if err := ValidatePolicy(policy); err != nil {
+		return nil, err
+	}
+	fixed := 0
+	switch policy {
+	case PolicyPatch:
+		fixed = 2
+	case PolicyMinor:
+		fixed = 1
+	}
+	return func(v *Version) bool {
+		for i := 0; i < fixed; i++ {
+			if v.part(i) != current.part(i) {
+				return false
+			}
+		}
+		return true
+	}, nil
+}
+
+// part returns the numeric part of the version: major, minor and patch of semver or `Numbers`
+func (v *Version) part(i int) int64 {
+	if len(v.Numbers) > 0 {
+		if i < len(v.Numbers) {
+			return v.Numbers[i]
+		}
+		return 0
+	}
+	switch i {
+	case 0:
+		return int64(v.Semver.Major)
+	case 1:
+		return int64(v.Semver.Minor)
+	case 2:
+		return int64(v.Semver.Patch)
+	}
+	return 0
+}
@@ -0,0 +1,89 @@
+package registry
+
+import (
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+)
+
+func TestReleasePolicy(t *testing.T) {
+	Convey("Test release channels", t, func() {
+		channels := map[string]string{
+			"1.2.0":           ChannelStable,
+			"2.0.0-rc.1":      ChannelRC,
+			"2.0.0-RC2":       ChannelRC,
+			"2.0.0-beta.3":    ChannelBeta,
+			"2.0.0-b1":        ChannelBeta,
+			"2.0.0-alpha":     ChannelAlpha,
+			"2.0.0-nightly.5": ChannelAlpha,
+			"2.0.0-1":         ChannelAlpha,
+		}
+		for tag, channel := range channels {
+			v, err := NewVersion(tag)
+			So(err, ShouldBeNil)
+			So(v.Channel(), ShouldEqual, channel)
+		}
+
+		rc, err := ChannelRange(ChannelRC)
+		So(err, ShouldBeNil)
+		for tag, allowed := range map[string]bool{"1.2.0": true, "2.0.0-rc.1": true, "2.0.0-beta.3": false} {
+			v, _ := NewVersion(tag)
+			So(rc(v), ShouldEqual, allowed)
+		}
+
+		stable, err := ChannelRange("")
+		So(err, ShouldBeNil)
+		v, _ := NewVersion("2.0.0-rc.1")
+		So(stable(v), ShouldBeFalse)
+
+		_, err = ChannelRange("edge")
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("Test relative update policies", t, func() {
+		current, _ := NewVersion("1.2.3")
+		allowed := map[string][]string{
+			PolicyPatch: {"1.2.4"},
+			PolicyMinor: {"1.2.4", "1.3.0"},
+			PolicyMajor: {"1.2.4", "1.3.0", "2.0.0"},
+		}
+		for policy, tags := range allowed {
+			r, err := PolicyRange(policy, current)
+			So(err, ShouldBeNil)
+			var got []string
+			for _, tag := range []string{"1.2.4", "1.3.0", "2.0.0"} {
+				if v, _ := NewVersion(tag); r(v) {
+					got = append(got, tag)
+				}
+			}
+			So(got, ShouldResemble, tags)
+		}
+
+		// Calendar versions keep the year and the month
+		calver, _ := CalverScheme{}.Parse("2024.06.1")
+		r, err := PolicyRange(PolicyPatch, calver)
+		So(err, ShouldBeNil)
+		v, _ := CalverScheme{}.Parse("2024.06.3")
+		So(r(v), ShouldBeTrue)
+		v, _ = CalverScheme{}.Parse("2024.07.0")
+		So(r(v), ShouldBeFalse)
+
+		_, err = PolicyRange("latest", current)
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("Test build metadata", t, func() {
+		scheme := SemverScheme{}
+		So(sortTags(scheme, "1.2.3_build.10", "1.2.3", "1.2.3_build.9", "1.2.2_build.11"),
+			ShouldResemble, []string{"1.2.2_build.11", "1.2.3", "1.2.3_build.9", "1.2.3_build.10"})
+
+		a, _ := scheme.Parse("1.2.3_build.10")
+		b, _ := scheme.Parse("1.2.3_build.9")
+		So(a.GT(b), ShouldBeTrue)
+
+		ignore := SemverScheme{IgnoreBuild: true}
+		a, _ = ignore.Parse("1.2.3_build.10")
+		b, _ = ignore.Parse("1.2.3_build.9")
+		So(a.GT(b), ShouldBeFalse)
+		So(a.Compare(b), ShouldEqual, 0)
+	})
+}
@@ -0,0 +1,103 @@
+package registry
+
+import (
+	"encoding/json"
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// CredentialProvider looks up credentials for a registry host
+type CredentialProvider interface {
+	// GetCredentials returns nil if the provider has no credentials for the host
+	GetCredentials(host string) (*Credentials, error)
+	// String describes the provider in logs
+	String() string
+}
+
+// ProviderChain tries credential providers in order
+type ProviderChain []CredentialProvider
+
+// DefaultProviders are tried for registries without credentials in image pull secrets
+var DefaultProviders ProviderChain
+
+// Find returns credentials for the host from the first provider which has them.
+// A provider failure is logged and the next provider is tried, so a broken provider does not prevent
+// fallback credentials or anonymous access. Credentials and provider are nil if no provider has credentials for the host.
+func (chain ProviderChain) Find(host string) (credentials *Credentials, provider CredentialProvider) {
+	host = NormalizeHost(host)
+	for _, p := range chain {
+		credentials, err := p.GetCredentials(host)
+		if err != nil {
+			log.Warnf("registry=%s no credentials from %s: %s", host, p, err)
+			continue
+		}
+		if credentials != nil {
+			return credentials, p
+		}
+	}
+	return nil, nil
+}
+
+// StaticCredentials provides credentials by registry host, e.g. `auths` of a docker config file
+type StaticCredentials struct {
+	Name  string
+	Items map[string]*Credentials
+}
+
+// GetCredentials implements CredentialProvider
+func (s *StaticCredentials) GetCredentials(host string) (*Credentials, error) {
+	return s.Items[NormalizeHost(host)], nil
+}
+
+func (s *StaticCredentials) String() string {
+	return s.Name
+}
+
+// DefaultDockerConfigPath returns a path of docker `config.json`: in `$DOCKER_CONFIG` or `~/.docker`
+func DefaultDockerConfigPath() string {
+	dir := os.Getenv("DOCKER_CONFIG")
+	if dir == "" {
+		dir = filepath.Join(os.Getenv("HOME"), ".docker")
+	}
+	return filepath.Join(dir, "config.json")
+}
+
+// LoadDockerConfig returns credential providers of a docker `config.json` file:
+// `auths` entries first, then `credHelpers` and `credsStore` credential helpers.
+func LoadDockerConfig(path string) (providers ProviderChain, err error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return
+	}
+	config := new(DockerConfigJSON)
+	if err = json.Unmarshal(data, config); err != nil {
+		err = fmt.Errorf("cannot parse docker config '%s': %s", path, err)
+		return
+	}
+	auths, err := normalizeAuths(config.Auths)
+	if err != nil {
+		err = fmt.Errorf("%s in docker config '%s'", err, path)
+		return
+	}
+	// Docker keeps empty `auths` entries for registries which credentials are in a credentials store
+	for host, creds := range auths {
+		if creds.Username == "" && creds.Password == "" && creds.IdentityToken == "" {
+			delete(auths, host)
+		}
+	}
+	helpers := &CredentialHelpers{
+		Helpers: make(map[string]string),
+		Default: config.CredsStore,
+	}
+	for host, helper := range config.CredHelpers {
+		helpers.Helpers[NormalizeHost(host)] = helper
+	}
+	providers = ProviderChain{
+		&StaticCredentials{Name: "docker config " + path, Items: auths},
+		helpers,
+	}
+	return
+}
@@ -0,0 +1,107 @@
+package registry
+
+import (
+	. "github.com/smartystreets/goconvey/convey"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// fakeHelper is a `docker-credential-fake` script which knows credentials for `helper.example.com`
+// and an identity token for `token.example.com`, fails for `broken.example.com`, and counts its runs
+const fakeHelper = `#!/bin/sh
+echo run >> "$(dirname "$0")/runs"
+read host
+case "$host" in
+helper.example.com) echo '{"ServerURL": "helper.example.com", "Username": "helper", "Secret": "pass"}' ;;
+token.example.com) echo '{"ServerURL": "token.example.com", "Username": "<token>", "Secret": "refresh"}' ;;
+broken.example.com) echo "error getting credentials - err: exit status 1"; exit 1 ;;
+*) echo "credentials not found in native keychain"; exit 1 ;;
+esac
+`
+
+func TestProviders(t *testing.T) {
+	dir, err := ioutil.TempDir("", "credentials")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ioutil.WriteFile(filepath.Join(dir, "docker-credential-fake"), []byte(fakeHelper), 0755)
+	path := os.Getenv("PATH")
+	os.Setenv("PATH", dir+string(os.PathListSeparator)+path)
+	defer os.Setenv("PATH", path)
+
+	config := filepath.Join(dir, "config.json")
+	ioutil.WriteFile(config, []byte(`{
+		"auths": {
+			"https://index.docker.io/v1/": {"auth": "dXNlcjpzZWNyZXQ="},
+			"helper.example.com": {}
+		},
+		"credHelpers": {"helper.example.com": "fake"},
+		"credsStore": "fake"
+	}`), 0644)
+
+	runs := func() int {
+		data, _ := ioutil.ReadFile(filepath.Join(dir, "runs"))
+		return len(data) / len("run\n")
+	}
+
+	Convey("Test credential providers", t, func() {
+		providers, err := LoadDockerConfig(config)
+		So(err, ShouldBeNil)
+
+		Convey("Config entries go before credential helpers", func() {
+			creds, provider := providers.Find("index.docker.io")
+			So(provider, ShouldEqual, providers[0])
+			So(creds.Username, ShouldEqual, "user")
+			So(creds.Password, ShouldEqual, "secret")
+		})
+
+		Convey("Credential helper results are cached", func() {
+			before := runs()
+			for i := 0; i < 2; i++ {
+				creds, provider := providers.Find("helper.example.com")
+				So(provider, ShouldEqual, providers[1])
+				So(creds.Username, ShouldEqual, "helper")
+				So(creds.Password, ShouldEqual, "pass")
+			}
+			So(runs()-before, ShouldEqual, 1)
+		})
+
+		Convey("Credential helper could return an identity token", func() {
+			creds, _ := providers.Find("token.example.com")
+			So(creds.IdentityToken, ShouldEqual, "refresh")
+		})
+
+		Convey("No credentials for unknown registry", func() {
+			creds, provider := providers.Find("unknown.example.com")
+			So(creds, ShouldBeNil)
+			So(provider, ShouldBeNil)
+		})
+
+		Convey("Failing helper has no credentials, the failure is cached", func() {
+			before := runs()
+			for i := 0; i < 2; i++ {
+				creds, provider := providers.Find("broken.example.com")
+				So(creds, ShouldBeNil)
+				So(provider, ShouldBeNil)
+			}
+			So(runs()-before, ShouldEqual, 1)
+		})
+
+		Convey("Missing helper has no credentials, next providers are tried", func() {
+			helpers := &CredentialHelpers{Default: "missing"}
+			creds, err := helpers.GetCredentials("registry.example.com")
+			So(err, ShouldBeNil)
+			So(creds, ShouldBeNil)
+
+			fallback := &StaticCredentials{Name: "fallback", Items: map[string]*Credentials{
+				"registry.example.com": {Username: "fallback", Password: "pass"},
+			}}
+			creds, provider := ProviderChain{helpers, fallback}.Find("registry.example.com")
+			So(provider, ShouldEqual, fallback)
+			So(creds.Username, ShouldEqual, "fallback")
+		})
+	})
+}
@@ -0,0 +1,325 @@
+// Package registrytest provides a fake Docker Registry v2 to test registry clients offline.
+package registrytest
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// MediaTypeOCIManifest is a media type of seeded manifests
+const MediaTypeOCIManifest = "application/vnd.oci.image.manifest.v1+json"
+
+// Registry is a fake registry serving `/v2/` API: tag lists with pagination, manifests, blobs
+// and bearer token authorization. Errors could be injected for any path.
+type Registry struct {
+	*httptest.Server
+
+	mu         sync.Mutex
+	repos      map[string]*repository
+	username   string
+	password   string
+	failures   []*failure
+	requests   map[string]int
+	tokenCount int
+}
+
+type repository struct {
+	// tags map to manifest digests
+	tags      map[string]string
+	manifests map[string][]byte
+	blobs     map[string][]byte
+}
+
+type failure struct {
+	path   string
+	status int
+	count  int
+}
+
+// NewRegistry starts a new fake registry. It should be closed with `Close` when done.
+func NewRegistry() *Registry {
+	r := &Registry{
+		repos:    make(map[string]*repository),
+		requests: make(map[string]int),
+	}
+	r.Server = httptest.NewServer(http.HandlerFunc(r.serveHTTP))
+	return r
+}
+
+// Host returns the registry host to use in image names, e.g. `127.0.0.1:35123`
+func (r *Registry) Host() string {
+	return strings.TrimPrefix(r.URL, "http://")
+}
+
+// RequireAuth enables bearer token authorization with the credentials
+func (r *Registry) RequireAuth(username, password string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.username = username
+	r.password = password
+}
+
+// AddTags seeds the repository with tags, each tag gets its own manifest
+func (r *Registry) AddTags(repo string, tags ...string) {
+	for _, tag := range tags {
+		r.PushManifest(repo, tag, newManifest(repo, tag))
+	}
+}
+
+// PushManifest puts the manifest to the repository with the tag and returns its digest
+func (r *Registry) PushManifest(repo, tag string, manifest []byte) (digest string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	digest = Digest(manifest)
+	rep := r.getRepo(repo)
+	rep.manifests[digest] = manifest
+	rep.tags[tag] = digest
+	return
+}
+
+// Retag points the tag to the manifest of the source tag, like `docker tag` and push does
+func (r *Registry) Retag(repo, tag, source string) (digest string, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	rep := r.getRepo(repo)
+	digest, ok := rep.tags[source]
+	if !ok {
+		err = fmt.Errorf("no tag '%s' in repository '%s'", source, repo)
+		return
+	}
+	rep.tags[tag] = digest
+	return
+}
+
+// GetDigest returns the manifest digest of the tag
+func (r *Registry) GetDigest(repo, tag string) string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.getRepo(repo).tags[tag]
+}
+
+// PushBlob puts the blob to the repository and returns its digest
+func (r *Registry) PushBlob(repo string, blob []byte) (digest string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	digest = Digest(blob)
+	r.getRepo(repo).blobs[digest] = blob
+	return
+}
+
+// Fail injects `count` failures with the status for requests with the path prefix.
+// Negative count makes all following requests fail.
+func (r *Registry) Fail(pathPrefix string, status int, count int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.failures = append(r.failures, &failure{path: pathPrefix, status: status, count: count})
+}
+
+// Requests returns a number of requests for paths with the prefix
+func (r *Registry) Requests(pathPrefix string) (count int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for path, n := range r.requests {
+		if strings.HasPrefix(path, pathPrefix) {
+			count += n
+		}
+	}
+	return
+}
+
+// Tokens returns a number of issued tokens
+func (r *Registry) Tokens() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.tokenCount
+}
+
+// Digest returns sha256 digest of the content
+func Digest(content []byte) string {
+	return fmt.Sprintf("sha256:%x", sha256.Sum256(content))
+}
+
+// newManifest returns a unique OCI manifest for the tag
+func newManifest(repo, tag string) []byte {
+	config := Digest([]byte(repo + ":" + tag))
+	return []byte(fmt.Sprintf(`{"schemaVersion":2,"mediaType":"%s","config":{"mediaType":"application/vnd.oci.image.config.v1+json","digest":"%s","size":2},"layers":[]}`,
+		MediaTypeOCIManifest, config))
+}
+
+func (r *Registry) getRepo(name string) *repository {
+	rep, ok := r.repos[name]
+	if !ok {
+		rep = &repository{
+			tags:      make(map[string]string),
+			manifests: make(map[string][]byte),
+			blobs:     make(map[string][]byte),
+		}
+		r.repos[name] = rep
+	}
+	return rep
+}
+
+func (r *Registry) serveHTTP(w http.ResponseWriter, req *http.Request) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.requests[req.URL.Path]++
+
+	if req.URL.Path == "/token" {
+		r.serveToken(w, req)
+		return
+	}
+	for _, f := range r.failures {
+		if f.count != 0 && strings.HasPrefix(req.URL.Path, f.path) {
+			if f.count > 0 {
+				f.count--
+			}
+			w.WriteHeader(f.status)
+			return
+		}
+	}
+	if !r.authorized(req) {
+		scope := ""
+		if name, _, ok := splitPath(req.URL.Path); ok {
+			scope = "repository:" + name + ":pull"
+		}
+		w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="registrytest",scope="%s"`, r.URL, scope))
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	if req.URL.Path == "/v2/" {
+		w.Header().Set("Docker-Distribution-API-Version", "registry/2.0")
+		fmt.Fprint(w, "{}")
+		return
+	}
+	name, rest, ok := splitPath(req.URL.Path)
+	rep, exists := r.repos[name]
+	if !ok || !exists {
+		writeError(w, http.StatusNotFound, "NAME_UNKNOWN", "repository name not known to registry")
+		return
+	}
+	switch {
+	case rest == "tags/list":
+		r.serveTags(w, req, name, rep)
+	case strings.HasPrefix(rest, "manifests/"):
+		serveManifest(w, req, rep, strings.TrimPrefix(rest, "manifests/"))
+	case strings.HasPrefix(rest, "blobs/"):
+		blob, ok := rep.blobs[strings.TrimPrefix(rest, "blobs/")]
+		if !ok {
+			writeError(w, http.StatusNotFound, "BLOB_UNKNOWN", "blob unknown to registry")
+			return
+		}
+		w.Header().Set("Docker-Content-Digest", Digest(blob))
+		w.Write(blob)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+// serveTags serves a sorted tag list page with `n` and `last` parameters and `Link` header
+func (r *Registry) serveTags(w http.ResponseWriter, req *http.Request, name string, rep *repository) {
+	var tags []string
+	for tag := range rep.tags {
+		tags = append(tags, tag)
+	}
+	sort.Strings(tags)
+
+	q := req.URL.Query()
+	if last := q.Get("last"); last != "" {
+		i := sort.SearchStrings(tags, last)
+		if i < len(tags) && tags[i] == last {
+			i++
+		}
+		tags = tags[i:]
+	}
+	if n, err := strconv.Atoi(q.Get("n")); err == nil && n > 0 && n < len(tags) {
+		tags = tags[:n]
+		next := url.Values{"n": {strconv.Itoa(n)}, "last": {tags[n-1]}}
+		w.Header().Set("Link", fmt.Sprintf(`</v2/%s/tags/list?%s>; rel="next"`, name, next.Encode()))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"name": name, "tags": tags})
+}
+
+func serveManifest(w http.ResponseWriter, req *http.Request, rep *repository, reference string) {
+	digest := reference
+	if !strings.HasPrefix(reference, "sha256:") {
+		digest = rep.tags[reference]
+	}
+	manifest, ok := rep.manifests[digest]
+	if !ok {
+		writeError(w, http.StatusNotFound, "MANIFEST_UNKNOWN", "manifest unknown")
+		return
+	}
+	accepted := strings.Join(req.Header["Accept"], ",")
+	if accepted != "" && !strings.Contains(accepted, MediaTypeOCIManifest) && !strings.Contains(accepted, "*/*") {
+		writeError(w, http.StatusNotFound, "MANIFEST_UNKNOWN", "manifest unknown in accepted formats")
+		return
+	}
+	w.Header().Set("Content-Type", MediaTypeOCIManifest)
+	w.Header().Set("Docker-Content-Digest", digest)
+	w.Header().Set("Content-Length", strconv.Itoa(len(manifest)))
+	if req.Method != "HEAD" {
+		w.Write(manifest)
+	}
+}
+
+// serveToken issues a token if the credentials are valid
+func (r *Registry) serveToken(w http.ResponseWriter, req *http.Request) {
+	username, password, _ := req.BasicAuth()
+	if username != r.username || password != r.password {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	r.tokenCount++
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"token":      r.token(req.URL.Query().Get("scope")),
+		"expires_in": 300,
+	})
+}
+
+func (r *Registry) token(scope string) string {
+	return Digest([]byte(r.username + ":" + r.password + ":" + scope))
+}
+
+// authorized checks the bearer token for the repository scope if auth is required
+func (r *Registry) authorized(req *http.Request) bool {
+	if r.username == "" && r.password == "" {
+		return true
+	}
+	scope := ""
+	if name, _, ok := splitPath(req.URL.Path); ok {
+		scope = "repository:" + name + ":pull"
+	}
+	return req.Header.Get("Authorization") == "Bearer "+r.token(scope)
+}
+
+// splitPath splits an API path like `/v2/<name>/tags/list` into the repository name and the rest
+func splitPath(path string) (name, rest string, ok bool) {
+	if !strings.HasPrefix(path, "/v2/") {
+		return
+	}
+	p := strings.TrimPrefix(path, "/v2/")
+	for _, sep := range []string{"/tags/", "/manifests/", "/blobs/"} {
+		if i := strings.LastIndex(p, sep); i > 0 {
+			return p[:i], p[i+1:], true
+		}
+	}
+	return
+}
+
+func writeError(w http.ResponseWriter, status int, code, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"errors": []map[string]string{{"code": code, "message": message}},
+	})
+}
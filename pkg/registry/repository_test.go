@@ -0,0 +1,69 @@
+package registry
+
+import (
+	"github.com/blang/semver"
+	"github.com/sabakaio/k8s-updater/pkg/reference"
+	"github.com/sabakaio/k8s-updater/pkg/registry/registrytest"
+	. "github.com/smartystreets/goconvey/convey"
+	"net/http"
+	"testing"
+)
+
+func TestRepository(t *testing.T) {
+	Convey("Test repository against fake registry", t, func() {
+		fake := registrytest.NewRegistry()
+		defer fake.Close()
+		fake.RequireAuth("user", "secret")
+		fake.AddTags("team/app", "1.0.0", "1.1.0", "1.2.0-rc.1", "2.0.0", "latest", "v1.9.1")
+
+		maxRetries := DefaultMaxRetries
+		DefaultMaxRetries = 0
+		defer func() { DefaultMaxRetries = maxRetries }()
+		So(SetConfigs([]*Config{{Host: fake.Host(), Scheme: "http"}}), ShouldBeNil)
+		defer SetConfigs(nil)
+
+		r, err := NewRegistry(fake.Host(), &Credentials{Username: "user", Password: "secret"})
+		So(err, ShouldBeNil)
+		r.PageSize = 2
+		ref, err := reference.Parse(fake.Host() + "/team/app:1.0.0")
+		So(err, ShouldBeNil)
+		repo := NewRepository(ref, r)
+		So(repo.Name, ShouldEqual, "team/app")
+
+		Convey("The latest version is found on all pages", func() {
+			version, err := repo.GetLatestVersion(nil)
+			So(err, ShouldBeNil)
+			So(version.Tag, ShouldEqual, "2.0.0")
+			So(fake.Requests("/v2/team/app/tags/list"), ShouldBeGreaterThan, 1)
+			So(fake.Tokens(), ShouldEqual, 1)
+		})
+
+		Convey("The latest version in range", func() {
+			version, err := repo.GetLatestVersion(SemverRange(semver.MustParseRange("<2.0.0")))
+			So(err, ShouldBeNil)
+			So(version.Tag, ShouldEqual, "v1.9.1")
+		})
+
+		Convey("Digest of the floating tag", func() {
+			_, err := fake.Retag("team/app", "latest", "2.0.0")
+			So(err, ShouldBeNil)
+			digest, err := repo.GetDigest("latest")
+			So(err, ShouldBeNil)
+			So(digest, ShouldEqual, fake.GetDigest("team/app", "2.0.0"))
+		})
+
+		Convey("Expect error if a page fails", func() {
+			fake.Fail("/v2/team/app/tags/list", http.StatusInternalServerError, -1)
+			version, err := repo.GetLatestVersion(nil)
+			So(err, ShouldNotBeNil)
+			So(version, ShouldBeNil)
+		})
+
+		Convey("Expect error with wrong credentials", func() {
+			other, err := NewRegistry(fake.Host(), &Credentials{Username: "user", Password: "wrong"})
+			So(err, ShouldBeNil)
+			_, err = other.GetTags("team/app")
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
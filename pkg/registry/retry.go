@@ -0,0 +1,228 @@
+package registry
+
+import (
+	"fmt"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var (
+	// DefaultMaxRetries is a number of retries of a failed registry request
+	DefaultMaxRetries = 3
+	// DefaultRequestBudget limits a number of requests to one registry, 0 is unlimited
+	DefaultRequestBudget = 0
+	// DefaultBreakerThreshold is a number of consecutive failed requests to consider a registry down
+	DefaultBreakerThreshold = 5
+)
+
+const (
+	minBackoff = 500 * time.Millisecond
+	maxBackoff = 10 * time.Second
+	// Do not wait longer than this even if a registry asks to with `Retry-After`
+	maxRetryAfter = time.Minute
+	// How long a registry is considered down before the next try
+	breakerCooldown = time.Minute
+)
+
+// UnavailableError is returned if a registry is down or the request budget is exceeded
+type UnavailableError struct {
+	Host   string
+	Reason string
+}
+
+func (e *UnavailableError) Error() string {
+	return fmt.Sprintf("registry %s unavailable: %s", e.Host, e.Reason)
+}
+
+// IsUnavailable checks if the error is caused by unavailable registry
+func IsUnavailable(err error) bool {
+	switch e := err.(type) {
+	case *UnavailableError:
+		return true
+	case *url.Error:
+		return IsUnavailable(e.Err)
+	case *PartialTagsError:
+		return IsUnavailable(e.Err)
+	}
+	return false
+}
+
+// RetryTransport retries failed requests with jittered exponential backoff, honouring `Retry-After`.
+// It opens a circuit once a registry fails `BreakerThreshold` requests in a row, so the following
+// requests fail fast with `UnavailableError` instead of waiting for their own timeouts.
+type RetryTransport struct {
+	Host string
+	// Transport is used to make actual requests, `http.DefaultTransport` if nil
+	Transport  http.RoundTripper
+	MaxRetries int
+	// Budget limits a number of requests including retries, 0 is unlimited
+	Budget           int
+	BreakerThreshold int
+
+	mu       sync.Mutex
+	requests int
+	failures int
+	openedAt time.Time
+	// sleep is replaced in tests
+	sleep func(time.Duration)
+}
+
+// retryTransports are shared by registries with the same host and different credentials
+var (
+	retryTransportsMu sync.Mutex
+	retryTransports   = map[string]*RetryTransport{}
+)
+
+// getRetryTransport returns a retry transport for the registry host
+func getRetryTransport(host string, cfg *Config, base http.RoundTripper) *RetryTransport {
+	retryTransportsMu.Lock()
+	defer retryTransportsMu.Unlock()
+	if t, ok := retryTransports[host]; ok {
+		return t
+	}
+	budget := DefaultRequestBudget
+	if cfg.RequestBudget > 0 {
+		budget = cfg.RequestBudget
+	}
+	t := &RetryTransport{
+		Host:             host,
+		Transport:        base,
+		MaxRetries:       DefaultMaxRetries,
+		Budget:           budget,
+		BreakerThreshold: DefaultBreakerThreshold,
+	}
+	retryTransports[host] = t
+	return t
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *RetryTransport) RoundTrip(req *http.Request) (res *http.Response, err error) {
+	// Requests with body could not be replayed
+	retries := t.MaxRetries
+	if req.Body != nil {
+		retries = 0
+	}
+
+	for attempt := 0; ; attempt++ {
+		if err = t.acquire(); err != nil {
+			return
+		}
+		res, err = t.transport().RoundTrip(req)
+		if !shouldRetry(res, err) {
+			t.report(true)
+			return
+		}
+		if attempt >= retries {
+			t.report(false)
+			return
+		}
+
+		wait := backoff(attempt)
+		if res != nil {
+			if after, ok := retryAfter(res); ok {
+				wait = after
+			}
+			res.Body.Close()
+		}
+		t.doSleep(wait)
+	}
+}
+
+func (t *RetryTransport) transport() http.RoundTripper {
+	if t.Transport != nil {
+		return t.Transport
+	}
+	return http.DefaultTransport
+}
+
+func (t *RetryTransport) doSleep(d time.Duration) {
+	if t.sleep != nil {
+		t.sleep(d)
+		return
+	}
+	time.Sleep(d)
+}
+
+// acquire checks the circuit breaker and the request budget before a request
+func (t *RetryTransport) acquire() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.openedAt.IsZero() {
+		if time.Since(t.openedAt) < breakerCooldown {
+			return &UnavailableError{Host: t.Host, Reason: fmt.Sprintf("%d requests failed in a row", t.failures)}
+		}
+		// Let one request try if the registry is back
+		t.openedAt = time.Time{}
+		t.failures = t.BreakerThreshold - 1
+	}
+	if t.Budget > 0 && t.requests >= t.Budget {
+		return &UnavailableError{Host: t.Host, Reason: fmt.Sprintf("request budget of %d exceeded", t.Budget)}
+	}
+	t.requests++
+	return nil
+}
+
+// report counts the request result for the circuit breaker
+func (t *RetryTransport) report(ok bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if ok {
+		t.failures = 0
+		return
+	}
+	t.failures++
+	if t.BreakerThreshold > 0 && t.failures >= t.BreakerThreshold {
+		t.openedAt = time.Now()
+	}
+}
+
+// shouldRetry checks if the request failed with a transient error
+func shouldRetry(res *http.Response, err error) bool {
+	if err != nil {
+		return true
+	}
+	switch res.StatusCode {
+	case http.StatusTooManyRequests, http.StatusInternalServerError, http.StatusBadGateway,
+		http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// backoff returns a random delay up to the exponential backoff for the attempt ("full jitter")
+func backoff(attempt int) time.Duration {
+	d := minBackoff << uint(attempt)
+	if d > maxBackoff || d <= 0 {
+		d = maxBackoff
+	}
+	return minBackoff/2 + time.Duration(rand.Int63n(int64(d)))
+}
+
+// retryAfter returns a delay from `Retry-After` header of 429 and 503 responses
+func retryAfter(res *http.Response) (d time.Duration, ok bool) {
+	if res.StatusCode != http.StatusTooManyRequests && res.StatusCode != http.StatusServiceUnavailable {
+		return
+	}
+	value := res.Header.Get("Retry-After")
+	if value == "" {
+		return
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		d = time.Duration(seconds) * time.Second
+	} else if date, err := http.ParseTime(value); err == nil {
+		d = date.Sub(time.Now())
+	} else {
+		return
+	}
+	if d < 0 {
+		d = 0
+	}
+	if d > maxRetryAfter {
+		d = maxRetryAfter
+	}
+	return d, true
+}
@@ -0,0 +1,87 @@
+package registry
+
+import (
+	. "github.com/smartystreets/goconvey/convey"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRetryTransport(t *testing.T) {
+	var responses []int
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		status := http.StatusOK
+		if requests < len(responses) {
+			status = responses[requests]
+		}
+		requests++
+		if status == http.StatusTooManyRequests {
+			w.Header().Set("Retry-After", "7")
+		}
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	Convey("Test registry request retries", t, func() {
+		requests = 0
+		var waits []time.Duration
+		transport := &RetryTransport{
+			Host:             "test",
+			MaxRetries:       3,
+			BreakerThreshold: 2,
+			sleep:            func(d time.Duration) { waits = append(waits, d) },
+		}
+		client := &http.Client{Transport: transport}
+
+		Convey("Transient errors are retried", func() {
+			responses = []int{http.StatusServiceUnavailable, http.StatusTooManyRequests}
+			res, err := client.Get(server.URL)
+			So(err, ShouldBeNil)
+			So(res.StatusCode, ShouldEqual, http.StatusOK)
+			So(requests, ShouldEqual, 3)
+			So(waits, ShouldHaveLength, 2)
+			So(waits[0], ShouldBeLessThanOrEqualTo, maxBackoff)
+			// `Retry-After` is honoured
+			So(waits[1], ShouldEqual, 7*time.Second)
+		})
+
+		Convey("Client errors are not retried", func() {
+			responses = []int{http.StatusNotFound}
+			res, err := client.Get(server.URL)
+			So(err, ShouldBeNil)
+			So(res.StatusCode, ShouldEqual, http.StatusNotFound)
+			So(requests, ShouldEqual, 1)
+		})
+
+		Convey("Circuit is opened if a registry is down", func() {
+			responses = []int{500, 500, 500, 500, 500, 500, 500, 500}
+			res, err := client.Get(server.URL)
+			So(err, ShouldBeNil)
+			So(res.StatusCode, ShouldEqual, http.StatusInternalServerError)
+			So(requests, ShouldEqual, 4)
+
+			res, err = client.Get(server.URL)
+			So(err, ShouldBeNil)
+			So(requests, ShouldEqual, 8)
+
+			// The next request fails fast
+			_, err = client.Get(server.URL)
+			So(IsUnavailable(err), ShouldBeTrue)
+			So(requests, ShouldEqual, 8)
+		})
+
+		Convey("Request budget is limited", func() {
+			responses = nil
+			transport.Budget = 2
+			_, err := client.Get(server.URL)
+			So(err, ShouldBeNil)
+			_, err = client.Get(server.URL)
+			So(err, ShouldBeNil)
+			_, err = client.Get(server.URL)
+			So(IsUnavailable(err), ShouldBeTrue)
+			So(requests, ShouldEqual, 2)
+		})
+	})
+}
@@ -0,0 +1,377 @@
+package registry
+
+import (
+	"fmt"
+	"github.com/blang/semver"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Version scheme names to choose with `autoupdate_version_scheme_<container>` annotation
+const (
+	SchemeSemver    = "semver"
+	SchemeCalver    = "calver"
+	SchemeInteger   = "integer"
+	SchemeTimestamp = "timestamp"
+)
+
+// VersionScheme parses image tags into versions, compares them and parses version ranges
+type VersionScheme interface {
+	// Parse returns a version of the tag, or an error if the tag is not a version of the scheme
+	Parse(tag string) (*Version, error)
+	// Compare returns -1, 0 or 1 if version a is less than, equal to or greater than b
+	Compare(a, b *Version) int
+	// ParseRange parses a version range like `>=1.2.0 <2.0.0`
+	ParseRange(s string) (VersionRange, error)
+}
+
+// VersionRange checks if a version is in the range
+type VersionRange func(*Version) bool
+
+// DefaultScheme is a name of the version scheme for containers without `autoupdate_version_scheme_<container>` annotation
+var DefaultScheme = SchemeSemver
+
+var (
+	schemesMu sync.Mutex
+	schemes   = map[string]VersionScheme{
+		SchemeSemver:    SemverScheme{},
+		SchemeCalver:    CalverScheme{},
+		SchemeInteger:   IntegerScheme{},
+		SchemeTimestamp: TimestampScheme{},
+	}
+)
+
+// RegisterScheme makes the version scheme available by the name
+func RegisterScheme(name string, scheme VersionScheme) {
+	schemesMu.Lock()
+	defer schemesMu.Unlock()
+	schemes[name] = scheme
+}
+
+// GetScheme returns a version scheme by the name, `DefaultScheme` if the name is empty
+func GetScheme(name string) (scheme VersionScheme, err error) {
+	if name == "" {
+		name = DefaultScheme
+	}
+	schemesMu.Lock()
+	defer schemesMu.Unlock()
+	scheme, ok := schemes[name]
+	if !ok {
+		err = fmt.Errorf("unknown version scheme '%s'", name)
+	}
+	return
+}
+
+// SemverRange makes a version range of a semver range. Versions of other schemes are out of the range.
+func SemverRange(r semver.Range) VersionRange {
+	return func(v *Version) bool {
+		return v.isSemver() && r(v.Semver)
+	}
+}
+
+// SemverScheme parses tags with `semver.ParseTolerant`, e.g. `v1.2` is `1.2.0`. Docker tags could not contain `+`,
+// so `_` separates build metadata, e.g. `1.2.3_build.5` is `1.2.3+build.5`.
+type SemverScheme struct {
+	// IgnoreBuild makes versions which differ by build metadata only equal.
+	// Otherwise build metadata is compared as prerelease identifiers are, so a rebuilt version is greater.
+	IgnoreBuild bool
+}
+
+// Parse implements VersionScheme
+func (s SemverScheme) Parse(tag string) (*Version, error) {
+	v, err := semver.ParseTolerant(strings.Replace(tag, "_", "+", 1))
+	if err != nil {
+		return nil, err
+	}
+	return &Version{Tag: tag, Semver: v, Scheme: s}, nil
+}
+
+// Compare implements VersionScheme
+func (s SemverScheme) Compare(a, b *Version) int {
+	if c := a.Semver.Compare(b.Semver); c != 0 || s.IgnoreBuild {
+		return c
+	}
+	return compareBuild(a.Semver.Build, b.Semver.Build)
+}
+
+// compareBuild compares build metadata identifiers, numeric ones as numbers
+func compareBuild(a, b []string) int {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		x, ex := strconv.ParseUint(a[i], 10, 64)
+		y, ey := strconv.ParseUint(b[i], 10, 64)
+		switch {
+		case ex == nil && ey == nil && x != y:
+			if x < y {
+				return -1
+			}
+			return 1
+		case ex == nil && ey != nil:
+			return -1
+		case ex != nil && ey == nil:
+			return 1
+		case a[i] < b[i]:
+			return -1
+		case a[i] > b[i]:
+			return 1
+		}
+	}
+	switch {
+	case len(a) < len(b):
+		return -1
+	case len(a) > len(b):
+		return 1
+	}
+	return 0
+}
+
+// ParseRange implements VersionScheme, the range is parsed with `ParseConstraint`
+func (SemverScheme) ParseRange(s string) (VersionRange, error) {
+	r, err := ParseConstraint(s)
+	if err != nil {
+		return nil, err
+	}
+	return SemverRange(r), nil
+}
+
+// numericScheme compares versions by their `Numbers`
+type numericScheme struct{}
+
+// Compare implements VersionScheme
+func (numericScheme) Compare(a, b *Version) int {
+	for i := 0; i < len(a.Numbers) || i < len(b.Numbers); i++ {
+		var x, y int64
+		if i < len(a.Numbers) {
+			x = a.Numbers[i]
+		}
+		if i < len(b.Numbers) {
+			y = b.Numbers[i]
+		}
+		if x < y {
+			return -1
+		}
+		if x > y {
+			return 1
+		}
+	}
+	return 0
+}
+
+// CalverScheme parses calendar versions like `2024.06.1`, `24.04` or `2024.6`.
+// The first part is a year, all parts are compared as numbers. Two digit years are years
+// of the 2000s, so `24.07` is greater than `2024.06` if a repository changes the year format.
+type CalverScheme struct{ numericScheme }
+
+var calverPattern = regexp.MustCompile(`^v?(\d{4}|\d{2})((?:\.\d+){1,3})$`)
+
+// Parse implements VersionScheme
+func (s CalverScheme) Parse(tag string) (*Version, error) {
+	m := calverPattern.FindStringSubmatch(tag)
+	if m == nil {
+		return nil, fmt.Errorf("'%s' is not a calendar version", tag)
+	}
+	parts := append([]string{m[1]}, strings.Split(m[2][1:], ".")...)
+	numbers := make([]int64, len(parts))
+	for i, p := range parts {
+		n, err := strconv.ParseInt(p, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		numbers[i] = n
+	}
+	if len(m[1]) == 2 {
+		numbers[0] += 2000
+	}
+	// A month is the second part of all common calendar versioning formats
+	if numbers[1] < 1 || numbers[1] > 12 {
+		return nil, fmt.Errorf("'%s' is not a calendar version, invalid month %d", tag, numbers[1])
+	}
+	return &Version{Tag: tag, Numbers: numbers, Scheme: s}, nil
+}
+
+// ParseRange implements VersionScheme
+func (s CalverScheme) ParseRange(r string) (VersionRange, error) {
+	return parseComparatorRange(s, r)
+}
+
+// IntegerScheme parses build numbers with an optional prefix like `4812`, `build-4812` or `r57`.
+// The prefix is kept in the version `Prefix`, tags of other prefixes like `pr-9999` are other series.
+type IntegerScheme struct{ numericScheme }
+
+var integerPattern = regexp.MustCompile(`^([^\d]*)(\d+)$`)
+
+// Parse implements VersionScheme
+func (s IntegerScheme) Parse(tag string) (*Version, error) {
+	m := integerPattern.FindStringSubmatch(tag)
+	if m == nil {
+		return nil, fmt.Errorf("'%s' is not a build number", tag)
+	}
+	n, err := strconv.ParseInt(m[2], 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	return &Version{Tag: tag, Numbers: []int64{n}, Prefix: m[1], Scheme: s}, nil
+}
+
+// ParseRange implements VersionScheme
+func (s IntegerScheme) ParseRange(r string) (VersionRange, error) {
+	return parseComparatorRange(s, r)
+}
+
+// TimestampScheme parses date and time tags like `20240612`, `20240612-1530` or `2024-06-12T153000`
+type TimestampScheme struct{ numericScheme }
+
+var timestampLayouts = map[int]string{
+	8:  "20060102",
+	12: "200601021504",
+	14: "20060102150405",
+}
+
+// Parse implements VersionScheme
+func (s TimestampScheme) Parse(tag string) (*Version, error) {
+	digits := strings.Map(func(r rune) rune {
+		switch r {
+		case '-', '_', '.', 'T':
+			return -1
+		}
+		return r
+	}, strings.TrimPrefix(tag, "v"))
+	layout, ok := timestampLayouts[len(digits)]
+	if !ok {
+		return nil, fmt.Errorf("'%s' is not a timestamp", tag)
+	}
+	t, err := time.Parse(layout, digits)
+	if err != nil {
+		return nil, fmt.Errorf("'%s' is not a timestamp: %s", tag, err)
+	}
+	return &Version{Tag: tag, Numbers: []int64{t.Unix()}, Scheme: s}, nil
+}
+
+// ParseRange implements VersionScheme
+func (s TimestampScheme) ParseRange(r string) (VersionRange, error) {
+	return parseComparatorRange(s, r)
+}
+
+// parseComparatorRange parses a range of comparators like `>=2024.01 <2025.01 || =2023.12.2`
+// with versions of the scheme. Space separated comparators are combined with AND, `||` is OR.
+func parseComparatorRange(scheme VersionScheme, s string) (VersionRange, error) {
+	var or []VersionRange
+	for _, part := range strings.Split(s, "||") {
+		var and []VersionRange
+		for _, comparator := range strings.Fields(part) {
+			f, err := parseComparator(scheme, comparator)
+			if err != nil {
+				return nil, err
+			}
+			and = append(and, f)
+		}
+		if len(and) == 0 {
+			return nil, fmt.Errorf("empty version range in '%s'", s)
+		}
+		or = append(or, func(v *Version) bool {
+			for _, f := range and {
+				if !f(v) {
+					return false
+				}
+			}
+			return true
+		})
+	}
+	return func(v *Version) bool {
+		for _, f := range or {
+			if f(v) {
+				return true
+			}
+		}
+		return false
+	}, nil
+}
+
+func parseComparator(scheme VersionScheme, s string) (VersionRange, error) {
+	i := strings.IndexFunc(s, func(r rune) bool { return !strings.ContainsRune("<>=!", r) })
+	if i < 0 {
+		return nil, fmt.Errorf("invalid version range '%s': no version", s)
+	}
+	op := s[:i]
+	bound, err := scheme.Parse(s[i:])
+	if err != nil {
+		return nil, fmt.Errorf("invalid version range '%s': %s", s, err)
+	}
+	var match func(int) bool
+	switch op {
+	case "", "=", "==":
+		match = func(c int) bool { return c == 0 }
+	case "!=", "!":
+		match = func(c int) bool { return c != 0 }
+	case ">":
+		match = func(c int) bool { return c > 0 }
+	case ">=":
+		match = func(c int) bool { return c >= 0 }
+	case "<":
+		match = func(c int) bool { return c < 0 }
+	case "<=":
+		match = func(c int) bool { return c <= 0 }
+	default:
+		return nil, fmt.Errorf("invalid operator '%s' in version range '%s'", op, s)
+	}
+	return func(v *Version) bool {
+		return match(scheme.Compare(v, bound))
+	}, nil
+}
+
+// PatternScheme parses a version out of tags matching the pattern, e.g. `13.4` out of `13.4-alpine`
+// with `^(?P<version>\d+\.\d+)-alpine$`. Tags not matching the pattern are not versions, so an image
+// keeps its variant. The version part is parsed and compared with the `Scheme`, the full tag is kept.
+type PatternScheme struct {
+	Pattern *regexp.Regexp
+	Scheme  VersionScheme
+}
+
+// NewPatternScheme returns a scheme for the pattern with a `version` named group
+func NewPatternScheme(pattern string, scheme VersionScheme) (s *PatternScheme, err error) {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		err = fmt.Errorf("invalid tag pattern: %s", err)
+		return
+	}
+	for _, name := range re.SubexpNames() {
+		if name == "version" {
+			s = &PatternScheme{Pattern: re, Scheme: scheme}
+			return
+		}
+	}
+	err = fmt.Errorf("tag pattern '%s' has no `(?P<version>...)` group", pattern)
+	return
+}
+
+// Parse implements VersionScheme
+func (s *PatternScheme) Parse(tag string) (*Version, error) {
+	m := s.Pattern.FindStringSubmatch(tag)
+	if m == nil {
+		return nil, fmt.Errorf("tag '%s' does not match pattern '%s'", tag, s.Pattern)
+	}
+	var part string
+	for i, name := range s.Pattern.SubexpNames() {
+		if name == "version" {
+			part = m[i]
+		}
+	}
+	v, err := s.Scheme.Parse(part)
+	if err != nil {
+		return nil, err
+	}
+	v.Tag = tag
+	return v, nil
+}
+
+// Compare implements VersionScheme
+func (s *PatternScheme) Compare(a, b *Version) int {
+	return s.Scheme.Compare(a, b)
+}
+
+// ParseRange implements VersionScheme. The range is for the version part of tags.
+func (s *PatternScheme) ParseRange(r string) (VersionRange, error) {
+	return s.Scheme.ParseRange(r)
+}
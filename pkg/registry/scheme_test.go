@@ -0,0 +1,124 @@
+package registry
+
+import (
+	. "github.com/smartystreets/goconvey/convey"
+	"sort"
+	"testing"
+)
+
+// sortTags parses the tags with the scheme and returns them sorted from the least version
+func sortTags(scheme VersionScheme, tags ...string) (sorted []string) {
+	var versions Versions
+	for _, tag := range tags {
+		v, err := scheme.Parse(tag)
+		So(err, ShouldBeNil)
+		versions = append(versions, v)
+	}
+	sort.Sort(versions)
+	for _, v := range versions {
+		sorted = append(sorted, v.Tag)
+	}
+	return
+}
+
+func TestVersionSchemes(t *testing.T) {
+	Convey("Test calendar versions", t, func() {
+		scheme := CalverScheme{}
+		So(sortTags(scheme, "2024.06.1", "2023.12", "2024.06", "2024.10.0", "2024.6.2"),
+			ShouldResemble, []string{"2023.12", "2024.06", "2024.06.1", "2024.6.2", "2024.10.0"})
+		// Two digit years are years of the 2000s
+		So(sortTags(scheme, "24.07.0", "2024.06.0", "23.12"),
+			ShouldResemble, []string{"23.12", "2024.06.0", "24.07.0"})
+		for _, tag := range []string{"1.2.3", "2024.13.1", "2024", "latest"} {
+			_, err := scheme.Parse(tag)
+			So(err, ShouldNotBeNil)
+		}
+
+		r, err := scheme.ParseRange(">=2024.01 <2025.01")
+		So(err, ShouldBeNil)
+		v, _ := scheme.Parse("2024.06.1")
+		So(r(v), ShouldBeTrue)
+		v, _ = scheme.Parse("2025.01.0")
+		So(r(v), ShouldBeFalse)
+	})
+
+	Convey("Test build numbers", t, func() {
+		scheme := IntegerScheme{}
+		So(sortTags(scheme, "build-4812", "build-999", "build-10000"),
+			ShouldResemble, []string{"build-999", "build-4812", "build-10000"})
+		So(sortTags(scheme, "r57", "r9"), ShouldResemble, []string{"r9", "r57"})
+
+		// Tags of other prefixes are other series
+		current, _ := scheme.Parse("build-4812")
+		So(current.Prefix, ShouldEqual, "build-")
+		series := SeriesRange(current)
+		for _, tag := range []string{"pr-9999", "sha-abc12345", "9999"} {
+			v, err := scheme.Parse(tag)
+			So(err, ShouldBeNil)
+			So(series(v), ShouldBeFalse)
+		}
+		v, _ := scheme.Parse("build-5000")
+		So(series(v), ShouldBeTrue)
+		So(SeriesRange(nil)(v), ShouldBeFalse)
+		_, err := scheme.Parse("1.2.3")
+		So(err, ShouldNotBeNil)
+
+		r, err := scheme.ParseRange("<5000 || =10000")
+		So(err, ShouldBeNil)
+		v, _ = scheme.Parse("build-10000")
+		So(r(v), ShouldBeTrue)
+		v, _ = scheme.Parse("build-5000")
+		So(r(v), ShouldBeFalse)
+
+		_, err = scheme.ParseRange(">=")
+		So(err, ShouldNotBeNil)
+		_, err = scheme.ParseRange("<build")
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("Test timestamps", t, func() {
+		scheme := TimestampScheme{}
+		So(sortTags(scheme, "20240612-1530", "20240612", "20240101-0900", "20240612-153001"),
+			ShouldResemble, []string{"20240101-0900", "20240612", "20240612-1530", "20240612-153001"})
+		for _, tag := range []string{"20241312", "2024061", "build-20240612"} {
+			_, err := scheme.Parse(tag)
+			So(err, ShouldNotBeNil)
+		}
+	})
+
+	Convey("Test tag patterns", t, func() {
+		scheme, err := NewPatternScheme(`^(?P<version>\d+\.\d+(\.\d+)?)-bullseye-slim$`, SemverScheme{})
+		So(err, ShouldBeNil)
+		v, err := scheme.Parse("18.2.0-bullseye-slim")
+		So(err, ShouldBeNil)
+		So(v.Tag, ShouldEqual, "18.2.0-bullseye-slim")
+		So(v.Semver.String(), ShouldEqual, "18.2.0")
+		for _, tag := range []string{"18.2.0", "18.2.0-alpine", "18.2.0-bullseye"} {
+			_, err = scheme.Parse(tag)
+			So(err, ShouldNotBeNil)
+		}
+		So(sortTags(scheme, "18.10-bullseye-slim", "18.2.0-bullseye-slim"),
+			ShouldResemble, []string{"18.2.0-bullseye-slim", "18.10-bullseye-slim"})
+
+		// The range is for the version part
+		r, err := scheme.ParseRange("<18.5.0")
+		So(err, ShouldBeNil)
+		So(r(v), ShouldBeTrue)
+
+		_, err = NewPatternScheme(`^(\d+)-alpine$`, SemverScheme{})
+		So(err, ShouldNotBeNil)
+		_, err = NewPatternScheme(`^(?P<version>`, SemverScheme{})
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("Test schemes by name", t, func() {
+		scheme, err := GetScheme("")
+		So(err, ShouldBeNil)
+		So(scheme, ShouldHaveSameTypeAs, SemverScheme{})
+		scheme, err = GetScheme(SchemeCalver)
+		So(err, ShouldBeNil)
+		So(scheme, ShouldHaveSameTypeAs, CalverScheme{})
+		_, err = GetScheme("unknown")
+		So(err, ShouldNotBeNil)
+	})
+}
@@ -0,0 +1,240 @@
+package registry
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/ghodss/yaml"
+	"github.com/sabakaio/k8s-updater/pkg/reference"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Tag source names to choose with config or annotations
+const (
+	SourceRegistry  = "registry"
+	SourceCatalog   = "catalog"
+	SourceOCILayout = "oci"
+)
+
+// TagSource lists tags and resolves manifest digests of image repositories
+type TagSource interface {
+	GetTags(repo *Repository) ([]string, error)
+	GetDigest(repo *Repository, tag string) (string, error)
+}
+
+// DefaultSource is a name of the tag source for images without `autoupdate_source_<container>` annotation
+var DefaultSource = SourceRegistry
+
+var (
+	sourcesMu sync.Mutex
+	sources   = map[string]TagSource{SourceRegistry: RegistrySource{}}
+)
+
+// RegisterSource makes the tag source available by the name
+func RegisterSource(name string, source TagSource) {
+	sourcesMu.Lock()
+	defer sourcesMu.Unlock()
+	sources[name] = source
+}
+
+// GetSource returns a tag source by the name, `DefaultSource` if the name is empty
+func GetSource(name string) (source TagSource, err error) {
+	if name == "" {
+		name = DefaultSource
+	}
+	sourcesMu.Lock()
+	defer sourcesMu.Unlock()
+	source, ok := sources[name]
+	if !ok {
+		err = fmt.Errorf("unknown tag source '%s'", name)
+	}
+	return
+}
+
+// RegistrySource lists tags with the Docker Registry API of the repository registry
+type RegistrySource struct{}
+
+// GetTags implements TagSource
+func (RegistrySource) GetTags(repo *Repository) ([]string, error) {
+	if repo.Registry == nil {
+		return nil, fmt.Errorf("no registry for repository '%s'", repo.Name)
+	}
+	return repo.Registry.GetTags(repo.Name)
+}
+
+// GetDigest implements TagSource
+func (RegistrySource) GetDigest(repo *Repository, tag string) (string, error) {
+	if repo.Registry == nil {
+		return "", fmt.Errorf("no registry for repository '%s'", repo.Name)
+	}
+	return repo.Registry.GetDigest(repo.Name, tag)
+}
+
+// Catalog is a static list of approved image versions, e.g. for air-gapped clusters.
+// It is parsed from YAML or JSON like
+//
+//	images:
+//	  - name: registry.example.com/team/app
+//	    tags: ["1.2.0", "1.3.0"]
+//	    digests:
+//	      1.3.0: sha256:...
+type Catalog struct {
+	Images []*CatalogImage `json:"images"`
+
+	byName map[string]*CatalogImage
+}
+
+// CatalogImage is a list of approved versions of an image with optional digests
+type CatalogImage struct {
+	Name    string            `json:"name"`
+	Tags    []string          `json:"tags"`
+	Digests map[string]string `json:"digests"`
+}
+
+// ParseCatalog parses a catalog from YAML or JSON
+func ParseCatalog(data []byte) (catalog *Catalog, err error) {
+	catalog = new(Catalog)
+	if err = yaml.Unmarshal(data, catalog); err != nil {
+		catalog = nil
+		err = fmt.Errorf("cannot parse catalog: %s", err)
+		return
+	}
+	catalog.byName = make(map[string]*CatalogImage)
+	for _, image := range catalog.Images {
+		ref, e := reference.Parse(image.Name)
+		if e != nil {
+			catalog = nil
+			err = fmt.Errorf("invalid image in catalog: %s", e)
+			return
+		}
+		catalog.byName[ref.FullName()] = image
+	}
+	return
+}
+
+// LoadCatalog reads a catalog file
+func LoadCatalog(path string) (*Catalog, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseCatalog(data)
+}
+
+func (c *Catalog) get(repo *Repository) (*CatalogImage, error) {
+	image, ok := c.byName[repo.FullName()]
+	if !ok {
+		return nil, fmt.Errorf("image '%s' is not in the catalog", repo.FullName())
+	}
+	return image, nil
+}
+
+// GetTags implements TagSource
+func (c *Catalog) GetTags(repo *Repository) ([]string, error) {
+	image, err := c.get(repo)
+	if err != nil {
+		return nil, err
+	}
+	return append([]string(nil), image.Tags...), nil
+}
+
+// GetDigest implements TagSource
+func (c *Catalog) GetDigest(repo *Repository, tag string) (string, error) {
+	image, err := c.get(repo)
+	if err != nil {
+		return "", err
+	}
+	digest, ok := image.Digests[tag]
+	if !ok {
+		return "", fmt.Errorf("catalog has no digest for '%s:%s'", repo.FullName(), tag)
+	}
+	return digest, nil
+}
+
+// OCI image layout annotation with the tag of a manifest
+const ociRefNameAnnotation = "org.opencontainers.image.ref.name"
+
+// OCILayout lists tags of OCI image layout directories, e.g. written by `skopeo copy ... oci:<dir>:<tag>`.
+// A layout of a repository is looked for at `<Dir>/<domain>/<path>`, then at `<Dir>/<path>`.
+// Full reference ref names of other images in the layout are skipped.
+type OCILayout struct {
+	Dir string
+}
+
+// ociIndex is `index.json` of OCI image layout
+type ociIndex struct {
+	Manifests []struct {
+		Digest      string            `json:"digest"`
+		Annotations map[string]string `json:"annotations"`
+	} `json:"manifests"`
+}
+
+// readIndex returns tags of the repository layout mapped to manifest digests
+func (l *OCILayout) readIndex(repo *Repository) (tags map[string]string, err error) {
+	var data []byte
+	for _, dir := range []string{repo.FullName(), repo.Name} {
+		data, err = ioutil.ReadFile(filepath.Join(l.Dir, filepath.FromSlash(dir), "index.json"))
+		if err == nil || !os.IsNotExist(err) {
+			break
+		}
+	}
+	if err != nil {
+		err = fmt.Errorf("cannot read OCI layout for '%s': %s", repo.FullName(), err)
+		return
+	}
+	index := new(ociIndex)
+	if err = json.Unmarshal(data, index); err != nil {
+		err = fmt.Errorf("invalid OCI layout index for '%s': %s", repo.FullName(), err)
+		return
+	}
+	repoRef, err := reference.Parse(repo.FullName())
+	if err != nil {
+		return
+	}
+	tags = make(map[string]string)
+	for _, m := range index.Manifests {
+		name, ok := m.Annotations[ociRefNameAnnotation]
+		if !ok {
+			continue
+		}
+		// A ref name could be a tag or a full reference, a layout could hold other images too
+		if ref, e := reference.Parse(name); e == nil && strings.Contains(name, ":") {
+			if !ref.SameName(repoRef) {
+				continue
+			}
+			name = ref.Tag
+		}
+		if name != "" {
+			tags[name] = m.Digest
+		}
+	}
+	return
+}
+
+// GetTags implements TagSource
+func (l *OCILayout) GetTags(repo *Repository) (tags []string, err error) {
+	index, err := l.readIndex(repo)
+	if err != nil {
+		return
+	}
+	for tag := range index {
+		tags = append(tags, tag)
+	}
+	return
+}
+
+// GetDigest implements TagSource
+func (l *OCILayout) GetDigest(repo *Repository, tag string) (digest string, err error) {
+	index, err := l.readIndex(repo)
+	if err != nil {
+		return
+	}
+	digest, ok := index[tag]
+	if !ok {
+		err = fmt.Errorf("no tag '%s' in OCI layout for '%s'", tag, repo.FullName())
+	}
+	return
+}
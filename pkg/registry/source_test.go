@@ -0,0 +1,104 @@
+package registry
+
+import (
+	"github.com/sabakaio/k8s-updater/pkg/reference"
+	. "github.com/smartystreets/goconvey/convey"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func newSourceRepository(image string, source TagSource) *Repository {
+	ref, err := reference.Parse(image)
+	if err != nil {
+		panic(err)
+	}
+	repo := NewRepository(ref, nil)
+	repo.Source = source
+	return repo
+}
+
+func TestCatalog(t *testing.T) {
+	Convey("Test catalog tag source", t, func() {
+		catalog, err := ParseCatalog([]byte(`
+images:
+  - name: registry.example.com/team/app
+    tags: ["1.2.0", "1.3.0", "stable"]
+    digests:
+      1.3.0: sha256:new
+      stable: sha256:new
+  - name: nginx
+    tags: ["1.10.1"]
+`))
+		So(err, ShouldBeNil)
+
+		Convey("Versions are listed for images in the catalog", func() {
+			repo := newSourceRepository("registry.example.com/team/app:1.2.0", catalog)
+			latest, err := repo.GetLatestVersion(nil)
+			So(err, ShouldBeNil)
+			So(latest.Tag, ShouldEqual, "1.3.0")
+
+			version, err := repo.ResolveTag("stable", nil)
+			So(err, ShouldBeNil)
+			So(version.Tag, ShouldEqual, "1.3.0")
+		})
+
+		Convey("Docker Hub names are normalized", func() {
+			tags, err := newSourceRepository("docker.io/library/nginx", catalog).GetTags()
+			So(err, ShouldBeNil)
+			So(tags, ShouldResemble, []string{"1.10.1"})
+		})
+
+		Convey("Expect error for images not in the catalog", func() {
+			_, err := newSourceRepository("redis:3.2.0", catalog).GetTags()
+			So(err, ShouldNotBeNil)
+			_, err = newSourceRepository("nginx", catalog).GetDigest("1.10.1")
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+func TestOCILayout(t *testing.T) {
+	dir, err := ioutil.TempDir("", "oci-layout")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	layout := filepath.Join(dir, "registry.example.com", "team", "app")
+	os.MkdirAll(layout, 0755)
+	ioutil.WriteFile(filepath.Join(layout, "index.json"), []byte(`{
+		"schemaVersion": 2,
+		"manifests": [
+			{"digest": "sha256:old", "annotations": {"org.opencontainers.image.ref.name": "1.2.0"}},
+			{"digest": "sha256:new", "annotations": {"org.opencontainers.image.ref.name": "registry.example.com/team/app:1.3.0"}},
+			{"digest": "sha256:nginx", "annotations": {"org.opencontainers.image.ref.name": "docker.io/library/nginx:1.25"}},
+			{"digest": "sha256:hub", "annotations": {"org.opencontainers.image.ref.name": "team/app:9.9.9"}},
+			{"digest": "sha256:untagged"}
+		]
+	}`), 0644)
+
+	Convey("Test OCI image layout tag source", t, func() {
+		source := &OCILayout{Dir: dir}
+		repo := newSourceRepository("registry.example.com/team/app:1.2.0", source)
+
+		latest, err := repo.GetLatestVersion(nil)
+		So(err, ShouldBeNil)
+		So(latest.Tag, ShouldEqual, "1.3.0")
+
+		digest, err := repo.GetDigest("1.3.0")
+		So(err, ShouldBeNil)
+		So(digest, ShouldEqual, "sha256:new")
+
+		// Tags of other images in the layout are not versions of the repository
+		tags, err := repo.GetTags()
+		So(err, ShouldBeNil)
+		So(tags, ShouldHaveLength, 2)
+		_, err = repo.GetDigest("1.25")
+		So(err, ShouldNotBeNil)
+
+		_, err = newSourceRepository("registry.example.com/team/other", source).GetTags()
+		So(err, ShouldNotBeNil)
+	})
+}
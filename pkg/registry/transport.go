@@ -2,16 +2,128 @@ package registry
 
 import (
 	"net/http"
+	"sync"
 )
 
-type BasicAuthTransport struct {
+// AuthTransport authorizes registry API requests. It sends basic auth credentials and
+// follows the Docker Registry v2 token flow if a registry responds with a `Bearer` challenge.
+// Tokens are cached by the scope of the request path until they expire.
+type AuthTransport struct {
 	Username string
 	Password string
+	// IdentityToken is exchanged for bearer tokens with OAuth2 `refresh_token` grant instead of basic auth
+	IdentityToken string
+	// UserAgent is set for all requests including token requests
+	UserAgent string
+	// Transport is used to make actual requests, `http.DefaultTransport` if nil
+	Transport http.RoundTripper
+
+	mu     sync.Mutex
+	tokens map[string]*token
+	// scopes requested by the registry challenge for a path scope, if it differs, e.g. has extra actions
+	scopes map[string]string
+	// the last bearer challenge, reused to get tokens for new scopes without extra round trip
+	challenge *Challenge
+}
+
+func (t *AuthTransport) transport() http.RoundTripper {
+	if t.Transport != nil {
+		return t.Transport
+	}
+	return http.DefaultTransport
 }
 
-func (t *BasicAuthTransport) RoundTrip(req *http.Request) (*http.Response, error) {
-	if t.Username != "" || t.Password != "" {
-		req.SetBasicAuth(t.Username, t.Password)
+// RoundTrip implements http.RoundTripper
+func (t *AuthTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	scope := scopeFromPath(req.URL.Path)
+
+	// Use a cached token if any, or get a new one if the registry is known to use tokens
+	tok, err := t.getToken(scope, false)
+	if err != nil {
+		return nil, err
+	}
+	res, err := t.transport().RoundTrip(t.authorize(req, tok))
+	if err != nil || res.StatusCode != http.StatusUnauthorized {
+		return res, err
+	}
+
+	// Follow the bearer challenge if any
+	var challenge *Challenge
+	for _, c := range ParseChallenges(res.Header) {
+		if c.Scheme == "bearer" {
+			challenge = c
+			break
+		}
+	}
+	if challenge == nil {
+		return res, nil
+	}
+	t.mu.Lock()
+	t.challenge = challenge
+	if s, ok := challenge.Parameters["scope"]; ok && s != "" && s != scope {
+		if t.scopes == nil {
+			t.scopes = make(map[string]string)
+		}
+		t.scopes[scope] = s
+	}
+	t.mu.Unlock()
+
+	tok, err = t.getToken(scope, true)
+	if err != nil {
+		res.Body.Close()
+		return nil, err
+	}
+	res.Body.Close()
+	return t.transport().RoundTrip(t.authorize(req, tok))
+}
+
+// getToken returns a cached token for the path scope. A new token is requested
+// if there is no valid one in the cache, or `refresh` is set. The token is requested
+// for the scope of the registry challenge, but cached by the path scope to be found by next requests.
+func (t *AuthTransport) getToken(scope string, refresh bool) (tok *token, err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if !refresh {
+		if tok = t.tokens[scope]; tok.valid() {
+			return
+		}
+	}
+	tok = nil
+	if t.challenge == nil {
+		return
+	}
+	requested := scope
+	if s, ok := t.scopes[scope]; ok {
+		requested = s
+	}
+	tok, err = t.fetchToken(t.challenge, requested)
+	if err != nil {
+		return
+	}
+	if t.tokens == nil {
+		t.tokens = make(map[string]*token)
+	}
+	t.tokens[scope] = tok
+	return
+}
+
+// authorize returns a copy of the request with authorization header set.
+// A RoundTripper should not modify the original request.
+func (t *AuthTransport) authorize(req *http.Request, tok *token) *http.Request {
+	r := new(http.Request)
+	*r = *req
+	r.Header = make(http.Header, len(req.Header))
+	for k, v := range req.Header {
+		r.Header[k] = append([]string(nil), v...)
+	}
+	if t.UserAgent != "" {
+		r.Header.Set("User-Agent", t.UserAgent)
+	}
+	if tok != nil {
+		r.Header.Set("Authorization", "Bearer "+tok.Token)
+	} else if t.Username != "" || t.Password != "" {
+		r.SetBasicAuth(t.Username, t.Password)
 	}
-	return http.DefaultTransport.RoundTrip(req)
+	return r
 }
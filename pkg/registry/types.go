@@ -1,15 +1,68 @@
 package registry
 
 import (
+	"fmt"
 	"github.com/blang/semver"
 	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// DefaultPageSize is a number of tags to request per page of a tag list, 0 lets a registry decide
+	DefaultPageSize = 100
+	// DefaultMaxPages limits a number of tag list pages to fetch for one repository, 0 is unlimited
+	DefaultMaxPages = 50
 )
 
 // Registry is a docker registry with `Name` and private `credentials`
 type Registry struct {
-	Name        string
+	Name string
+	// Scheme of API requests, `https` if empty
+	Scheme string
+	// PageSize is a number of tags to request per page
+	PageSize int
+	// MaxPages limits a number of tag list pages to fetch
+	MaxPages int
+	// CacheTTL is a lifetime of cached tag lists and digests, 0 to keep them for the registry lifetime
+	CacheTTL time.Duration
+	// Revalidate expired tag lists with `If-None-Match` request
+	Revalidate  bool
 	credentials *Credentials
 	client      *http.Client
+
+	mu sync.Mutex
+	// tag lists by repository
+	tags map[string]*tagsEntry
+	// manifest digests by `repo:tag`
+	digests map[string]*digestEntry
+}
+
+// tagsEntry is a cached tag list of a repository
+type tagsEntry struct {
+	tags    []string
+	etag    string
+	pages   int
+	fetched time.Time
+}
+
+func (e *tagsEntry) copyTags() []string {
+	return append([]string(nil), e.tags...)
+}
+
+// tagsPage is a page of a tag list
+type tagsPage struct {
+	tags        []string
+	next        string
+	etag        string
+	notModified bool
+}
+
+// digestEntry is a cached manifest digest
+type digestEntry struct {
+	digest  string
+	fetched time.Time
 }
 
 // Credentials is a structure to unmarshall .dockercfg data
@@ -18,16 +71,25 @@ type Credentials struct {
 	Password string `json:"password"`
 	Email    string `json:"email"`
 	Auth     string `json:"auth"`
+	// IdentityToken is an OAuth2 refresh token to get registry tokens with instead of a password
+	IdentityToken string `json:"identitytoken"`
 }
 
+// RegistryList is a list of registries with credentials of image pull secrets
 type RegistryList struct {
 	Items []*Registry
+	// sources describe where credentials of registries came from, by registry name
+	sources map[string]string
 }
 
-// Repository is an image repository in a registry
+// Repository is an image repository in a registry. `Name` is a path in the registry, e.g. `library/nginx`.
+// Tags and digests are taken from the `Source`, tags are parsed into versions with the `Scheme`.
 type Repository struct {
 	Name     string
+	Domain   string
 	Registry *Registry
+	Source   TagSource
+	Scheme   VersionScheme
 }
 
 // TagList is a get tags API call response
@@ -36,10 +98,45 @@ type TagsList struct {
 	Tags []string `json:"tags"`
 }
 
-// Image version with repository tag and semver representation. `Tag` shoulf be used
-// to get image by its tag. `Semver` is for version compare.
+// PartialTagsError is returned if a tag list was fetched partially.
+// The tags got before the failure are returned with the error.
+type PartialTagsError struct {
+	Repo  string
+	Pages int
+	Tags  int
+	Err   error
+}
+
+func (e *PartialTagsError) Error() string {
+	return fmt.Sprintf("tag list for '%s' is incomplete, got %d tags from %d pages: %s", e.Repo, e.Tags, e.Pages, e.Err)
+}
+
+// Image version with repository tag and its parsed representation. `Tag` shoulf be used
+// to get image by its tag. Versions are compared with the `Scheme`: `Semver` is set for semver
+// versions, `Numbers` for calver, integer and timestamp ones. `Digest` is set if the
+// image should be pinned to the exact manifest.
 // TODO make it consistent, changing one field should affect another
 type Version struct {
 	Tag    string
 	Semver semver.Version
+	// Numbers are numeric parts of the version compared in order
+	Numbers []int64
+	// Prefix of a build number, e.g. `build-` of `build-4812`. Versions of different prefixes are different series.
+	Prefix string
+	Digest string
+	// Scheme the version was parsed with, semver if nil
+	Scheme VersionScheme
+}
+
+// Versions is a list of versions sortable by their scheme
+type Versions []*Version
+
+func (v Versions) Len() int      { return len(v) }
+func (v Versions) Swap(i, j int) { v[i], v[j] = v[j], v[i] }
+func (v Versions) Less(i, j int) bool {
+	if c := v[i].Compare(v[j]); c != 0 {
+		return c < 0
+	}
+	// `1.8.3` is more specific than `1.8` which is `1.8.0` too
+	return strings.Count(v[i].Tag, ".") < strings.Count(v[j].Tag, ".")
 }
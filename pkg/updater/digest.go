@@ -0,0 +1,100 @@
+package updater
+
+import (
+	"fmt"
+	"github.com/sabakaio/k8s-updater/pkg/reference"
+	"github.com/sabakaio/k8s-updater/pkg/registry"
+)
+
+// Pod template annotation to record the digest a followed tag was rolled out with
+const digestAnnotationPrefix = "autoupdate_digest_"
+
+// FollowDigest checks if the container follows its image tag by digest
+// with `autoupdate_follow_digest_<container>` annotation, e.g. for `latest` or `stable` tags
+func (c *Container) FollowDigest() bool {
+	return c.getBoolAnnotation("autoupdate_follow_digest_" + c.GetName())
+}
+
+// GetDigestVersion returns a version to roll out if the container image tag points to a new digest.
+// The last digest is taken from the image reference if it is pinned, or from the pod template annotation.
+// nil will be returned if the tag still points to the same digest.
+func (c *Container) GetDigestVersion() (version *registry.Version, err error) {
+	ref, err := c.GetImageReference()
+	if err != nil {
+		return
+	}
+	if ref.Tag == "" {
+		err = fmt.Errorf("invalid image name, could not extract tag to follow: %s", c.GetImageName())
+		return
+	}
+	digest, err := c.repository.GetDigest(ref.Tag)
+	if err != nil {
+		return
+	}
+
+	if c.getRecordedDigest(ref) == digest {
+		return
+	}
+
+	version = &registry.Version{Tag: ref.Tag, Digest: digest}
+	// A followed tag is not required to be a version, but keep the parsed version if it is
+	if v, e := c.repository.ParseVersion(ref.Tag); e == nil {
+		version = v
+		version.Digest = digest
+	}
+	return
+}
+
+// TrackTag returns a floating tag the container tracks with `autoupdate_track_<container>` annotation.
+// The container is updated to the version tag which points to the same manifest, e.g. `1.8.3` for `stable`.
+func (c *Container) TrackTag() string {
+	return c.workload.GetAnnotations()["autoupdate_track_"+c.GetName()]
+}
+
+// GetTrackedVersion returns a version tag the floating tag points to.
+// nil will be returned if the floating tag was not moved since the last update,
+// or the current image tag still points to the same manifest.
+func (c *Container) GetTrackedVersion(tag string) (version *registry.Version, err error) {
+	ref, err := c.GetImageReference()
+	if err != nil {
+		return
+	}
+	digest, err := c.repository.GetDigest(tag)
+	if err != nil {
+		return
+	}
+	if c.getRecordedDigest(ref) == digest {
+		return
+	}
+	// Only one manifest to check if the floating tag was not moved
+	if ref.Tag != "" && ref.Tag != tag {
+		if d, e := c.repository.GetDigest(ref.Tag); e == nil && d == digest {
+			return
+		}
+	}
+	filter, err := c.GetVersionFilter()
+	if err != nil {
+		return
+	}
+	return c.repository.ResolveTag(tag, filter)
+}
+
+// getRecordedDigest returns the digest the image was rolled out with. It is taken from the image
+// reference if it is pinned, or from the pod template annotation.
+func (c *Container) getRecordedDigest(ref *reference.Reference) string {
+	if ref.Digest != "" {
+		return ref.Digest
+	}
+	return c.workload.GetTemplate().GetAnnotations()[digestAnnotationPrefix+c.GetName()]
+}
+
+// setTemplateAnnotation sets the workload pod template annotation
+func (c *Container) setTemplateAnnotation(key, value string) {
+	template := c.workload.GetTemplate()
+	annotations := template.GetAnnotations()
+	if annotations == nil {
+		annotations = make(map[string]string)
+		template.SetAnnotations(annotations)
+	}
+	annotations[key] = value
+}
@@ -0,0 +1,81 @@
+package updater
+
+import (
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"github.com/sabakaio/k8s-updater/pkg/reference"
+)
+
+// Annotation prefix to keep an init container in lockstep with a main container
+const lockstepAnnotationPrefix = "autoupdate_lockstep_"
+
+// LockstepWith returns the main container the init container is kept in lockstep with
+// by `autoupdate_lockstep_<init container>` annotation. The init container is not checked on its own,
+// it gets the version of the main container when the main container is updated.
+func (c *Container) LockstepWith() string {
+	if !c.init {
+		return ""
+	}
+	return c.workload.GetAnnotations()[lockstepAnnotationPrefix+c.GetName()]
+}
+
+// setLockstepImages sets the version of the updated image to init containers in lockstep with the container.
+// An init container gets the same reference, including a pinned digest. Init containers of another image
+// are rejected by validation, the tag could be missing in their repository.
+func (c *Container) setLockstepImages(ref *reference.Reference) {
+	if c.init {
+		return
+	}
+	annotations := c.workload.GetAnnotations()
+	initContainers := c.workload.GetTemplate().Spec.InitContainers
+	for i, ic := range initContainers {
+		if annotations[lockstepAnnotationPrefix+ic.Name] != c.GetName() {
+			continue
+		}
+		initRef, err := reference.Parse(ic.Image)
+		if err != nil {
+			log.Errorf("workload=%s container=%s could not keep in lockstep with %s: %s",
+				c.GetWorkloadName(), ic.Name, c.GetName(), err)
+			continue
+		}
+		if !initRef.SameName(ref) {
+			log.Errorf("workload=%s container=%s could not keep in lockstep with %s: image %s is not %s",
+				c.GetWorkloadName(), ic.Name, c.GetName(), initRef.Name(), ref.Name())
+			continue
+		}
+		initContainers[i].Image = initRef.WithTag(ref.Tag).WithDigest(ref.Digest).String()
+		log.Debugf("workload=%s container=%s image %s in lockstep with container %s",
+			c.GetWorkloadName(), ic.Name, initContainers[i].Image, c.GetName())
+	}
+}
+
+// validateLockstep checks that the lockstep annotation is set on an init container and names a main container
+// of the same image
+func (c *Container) validateLockstep() error {
+	main, ok := c.workload.GetAnnotations()[lockstepAnnotationPrefix+c.GetName()]
+	if !ok {
+		return nil
+	}
+	if !c.init {
+		return fmt.Errorf("container '%s' is not an init container to keep in lockstep", c.GetName())
+	}
+	for _, mc := range c.workload.GetTemplate().Spec.Containers {
+		if mc.Name != main {
+			continue
+		}
+		initRef, err := reference.Parse(c.GetImageName())
+		if err != nil {
+			return fmt.Errorf("init container '%s': %s", c.GetName(), err)
+		}
+		ref, err := reference.Parse(mc.Image)
+		if err != nil {
+			return fmt.Errorf("container '%s': %s", main, err)
+		}
+		if !initRef.SameName(ref) {
+			return fmt.Errorf("init container '%s' runs image '%s', it could be in lockstep only with a container of the same image, not '%s'",
+				c.GetName(), initRef.Name(), ref.Name())
+		}
+		return nil
+	}
+	return fmt.Errorf("init container '%s' is in lockstep with '%s', which is not a container of the pod", c.GetName(), main)
+}
@@ -3,20 +3,23 @@ package updater
 import (
 	"fmt"
 	log "github.com/Sirupsen/logrus"
-	"github.com/blang/semver"
+	"github.com/sabakaio/k8s-updater/pkg/reference"
 	"github.com/sabakaio/k8s-updater/pkg/registry"
-	"github.com/sabakaio/k8s-updater/pkg/util"
 	"k8s.io/kubernetes/pkg/api"
 	"k8s.io/kubernetes/pkg/apis/batch"
 	client "k8s.io/kubernetes/pkg/client/unversioned"
 	"k8s.io/kubernetes/pkg/labels"
-	"strings"
-	"time"
+	"strconv"
 )
 
-// GetDeploymentName returns the deployment name
-func (c *Container) GetDeploymentName() string {
-	return c.deployment.Name
+// GetWorkload returns the workload running the container
+func (c *Container) GetWorkload() Workload {
+	return c.workload
+}
+
+// GetWorkloadName returns the workload kind and name, e.g. `deployment/web`
+func (c *Container) GetWorkloadName() string {
+	return WorkloadName(c.workload)
 }
 
 // GetName returns the container name
@@ -29,60 +32,254 @@ func (c *Container) GetImageName() string {
 	return c.container.Image
 }
 
+// GetImageReference returns parsed reference of the container image
+func (c *Container) GetImageReference() (*reference.Reference, error) {
+	return reference.Parse(c.GetImageName())
+}
+
 // GetImageVersion returns `registry.Version` for current container image
 func (c *Container) GetImageVersion() (version *registry.Version, err error) {
-	image := strings.SplitN(c.GetImageName(), ":", 2)
-	if len(image) != 2 {
+	ref, err := c.GetImageReference()
+	if err != nil {
+		return
+	}
+	if ref.Tag == "" {
 		err = fmt.Errorf("invalid image name, could not extract version: %s", c.GetImageName())
 		return
 	}
-	semver, err := semver.ParseTolerant(image[1])
+	scheme, err := c.GetVersionScheme()
+	if err != nil {
+		return
+	}
+	version, err = scheme.Parse(ref.Tag)
+	return
+}
+
+// GetVersionScheme returns the scheme to parse image tags with from `autoupdate_version_scheme_<container>` annotation.
+// Versions are taken from tags matching `autoupdate_tag_pattern_<container>` only if it is set.
+func (c *Container) GetVersionScheme() (scheme registry.VersionScheme, err error) {
+	annotations := c.workload.GetAnnotations()
+	scheme, err = registry.GetScheme(annotations["autoupdate_version_scheme_"+c.GetName()])
 	if err != nil {
 		return
 	}
-	version = &registry.Version{
-		Tag:    image[1],
-		Semver: semver,
+	if c.getBoolAnnotation("autoupdate_ignore_build_metadata_" + c.GetName()) {
+		if _, ok := scheme.(registry.SemverScheme); ok {
+			scheme = registry.SemverScheme{IgnoreBuild: true}
+		}
+	}
+	if pattern, ok := annotations["autoupdate_tag_pattern_"+c.GetName()]; ok {
+		patternScheme, e := registry.NewPatternScheme(pattern, scheme)
+		if e != nil {
+			err = e
+			return
+		}
+		scheme = patternScheme
 	}
 	return
 }
 
-// SetImageVersion updates Deployment template with the set version. It does not save the deployment.
+// SetImageVersion updates the workload pod template with the set version. It does not save the workload.
 // NOTE a version is passed by the value to avoid nil pointer errors
 func (c *Container) SetImageVersion(v registry.Version) (*Container, error) {
-	image := strings.SplitN(c.GetImageName(), ":", 2)
-	newImage := image[0] + ":" + v.String()
+	ref, err := c.GetImageReference()
+	if err != nil {
+		return c, err
+	}
+	ref = ref.WithTag(v.Tag)
+	if v.Digest != "" {
+		if c.PinDigest() {
+			ref = ref.WithDigest(v.Digest)
+		} else {
+			// Record the digest of a followed tag. Changing the pod template annotation
+			// also triggers a rollout if the tag itself is not changed.
+			c.setTemplateAnnotation(digestAnnotationPrefix+c.GetName(), v.Digest)
+		}
+	}
+	newImage := ref.String()
 	c.container.Image = newImage
-	for i, dc := range c.deployment.Spec.Template.Spec.Containers {
+	podContainers := c.podContainers()
+	for i, dc := range podContainers {
 		if dc.Name == c.GetName() {
-			c.deployment.Spec.Template.Spec.Containers[i].Image = newImage
+			podContainers[i].Image = newImage
 		}
 	}
+	c.setLockstepImages(ref)
 	return c, nil
 }
 
-// GetLatestVersion returns a latest image version from repository
-func (c *Container) GetLatestVersion() (*registry.Version, error) {
-	var filter semver.Range
-	annotations := c.deployment.GetAnnotations()
-	filter_key := "autoupdate_version_range_" + c.GetName()
-	for k, v := range annotations {
-		if k == filter_key {
-			r, e := semver.ParseRange(v)
-			if e != nil {
-				log.Errorln(e)
-				break
-			}
-			filter = r
-			break
+// IsInit checks if the container is an init container of the pod
+func (c *Container) IsInit() bool {
+	return c.init
+}
+
+// podContainers returns the pod template containers or init containers the container belongs to
+func (c *Container) podContainers() []api.Container {
+	spec := c.workload.GetTemplate().Spec
+	if c.init {
+		return spec.InitContainers
+	}
+	return spec.Containers
+}
+
+// GetLatestVersion returns a latest image version from repository in the versions range, the release channel
+// and the update policy. If the latest version is a prerelease, any final release newer than the current version is preferred,
+// e.g. `1.9.1` over `2.0.0-rc.1` for `1.9.0`, unless the container opts out with `autoupdate_prefer_final_<container>`.
+func (c *Container) GetLatestVersion() (latest *registry.Version, err error) {
+	filter, err := c.getUpdateFilter()
+	if err != nil {
+		return
+	}
+	current, currentErr := c.GetImageVersion()
+
+	latest, err = c.repository.GetLatestVersion(filter)
+	if err != nil || latest == nil || !latest.IsPrerelease() || !c.PreferFinal() || currentErr != nil {
+		return
+	}
+	final, e := c.repository.GetLatestVersion(registry.AllOf(filter, registry.FinalRelease))
+	if e == nil && final != nil && final.GT(current) {
+		latest = final
+	}
+	return
+}
+
+// getUpdateFilter returns a filter of versions the container could be updated to: the versions range,
+// the release channel, the build number series and the update policy relative to the current version
+func (c *Container) getUpdateFilter() (filter registry.VersionRange, err error) {
+	channel, err := registry.ChannelRange(c.GetReleaseChannel())
+	if err != nil {
+		return
+	}
+	versionRange, err := c.GetVersionFilter()
+	if err != nil {
+		return
+	}
+	filter = registry.AllOf(versionRange, channel)
+
+	// Relative policies need the current version, others work for any image tag
+	current, currentErr := c.GetImageVersion()
+	// Build numbers of other prefixes, e.g. `pr-9999` for `build-4812`, are not updates
+	filter = registry.AllOf(filter, registry.SeriesRange(current))
+	if policy := c.GetUpdatePolicy(); policy == registry.PolicyPatch || policy == registry.PolicyMinor {
+		if currentErr != nil {
+			err = fmt.Errorf("update policy '%s' needs the current version: %s", policy, currentErr)
+			return
 		}
+		policyRange, e := registry.PolicyRange(policy, current)
+		if e != nil {
+			err = e
+			return
+		}
+		filter = registry.AllOf(filter, policyRange)
+	}
+	return
+}
+
+// qualifies checks that the version is still allowed by annotations of the container, e.g. after the workload
+// is re-fetched: the versions range, the release channel, the build number series and the relative update policy.
+// A followed tag is not checked, a tracked tag is checked by the versions range only.
+func (c *Container) qualifies(v registry.Version) error {
+	if c.FollowDigest() {
+		return nil
+	}
+	scheme, err := c.GetVersionScheme()
+	if err != nil {
+		return err
+	}
+	version, err := scheme.Parse(v.Tag)
+	if err != nil {
+		return err
+	}
+	filter, err := c.GetVersionFilter()
+	if c.TrackTag() == "" && err == nil {
+		filter, err = c.getUpdateFilter()
+	}
+	if err != nil {
+		return err
+	}
+	if filter != nil && !filter(version) {
+		return fmt.Errorf("version %s is not allowed by autoupdate annotations", v.Tag)
+	}
+	return nil
+}
+
+// GetUpdatePolicy returns the update policy of the container from `autoupdate_policy_<container>` annotation:
+// `patch`, `minor`, `major` or `none`
+func (c *Container) GetUpdatePolicy() string {
+	if policy := c.workload.GetAnnotations()["autoupdate_policy_"+c.GetName()]; policy != "" {
+		return policy
+	}
+	return registry.DefaultPolicy
+}
+
+// GetReleaseChannel returns the release channel of the container from `autoupdate_channel_<container>` annotation:
+// `stable`, `rc`, `beta` or `alpha`
+func (c *Container) GetReleaseChannel() string {
+	if channel := c.workload.GetAnnotations()["autoupdate_channel_"+c.GetName()]; channel != "" {
+		return channel
+	}
+	return registry.DefaultChannel
+}
+
+// PreferFinal checks if the container should update to a final release before a newer prerelease
+// with `autoupdate_prefer_final_<container>` annotation
+func (c *Container) PreferFinal() bool {
+	key := "autoupdate_prefer_final_" + c.GetName()
+	if _, ok := c.workload.GetAnnotations()[key]; !ok {
+		return registry.DefaultPreferFinal
+	}
+	return c.getBoolAnnotation(key)
+}
+
+// GetVersionFilter returns versions range filter from `autoupdate_version_range_<container>` annotation.
+// The range is parsed with the container version scheme. The filter is nil if there is no annotation.
+func (c *Container) GetVersionFilter() (filter registry.VersionRange, err error) {
+	value, ok := c.workload.GetAnnotations()["autoupdate_version_range_"+c.GetName()]
+	if !ok {
+		return
+	}
+	scheme, err := c.GetVersionScheme()
+	if err != nil {
+		return
+	}
+	filter, err = scheme.ParseRange(value)
+	if err != nil {
+		err = fmt.Errorf("%s annotation autoupdate_version_range_%s: %s", c.GetWorkloadName(), c.GetName(), err)
 	}
-	return c.repository.GetLatestVersion(filter)
+	return
 }
 
 // GetAutoupdateVersion returns version to perform autoupdate to.
-// nil will be returned if notheng to update.
+// nil will be returned if notheng to update, or the update policy is `none`.
 func (c *Container) GetAutoupdateVersion() (version *registry.Version, err error) {
+	version, err = c.GetAvailableVersion()
+	if err == nil && version != nil && c.ReportOnly() {
+		version = nil
+	}
+	return
+}
+
+// GetAvailableVersion returns a version the container could be updated to, nil if it is up to date.
+// The version is returned for the `none` update policy too, check `ReportOnly` before updating.
+func (c *Container) GetAvailableVersion() (version *registry.Version, err error) {
+	if err = registry.ValidatePolicy(c.GetUpdatePolicy()); err != nil {
+		return
+	}
+	return c.getAutoupdateVersion()
+}
+
+// ReportOnly checks if available versions of the container are reported but not updated to, with `none` update policy
+func (c *Container) ReportOnly() bool {
+	return c.GetUpdatePolicy() == registry.PolicyNone
+}
+
+func (c *Container) getAutoupdateVersion() (version *registry.Version, err error) {
+	if c.FollowDigest() {
+		return c.GetDigestVersion()
+	}
+	if tag := c.TrackTag(); tag != "" {
+		return c.GetTrackedVersion(tag)
+	}
 	current, err := c.GetImageVersion()
 	if err != nil {
 		return
@@ -91,33 +288,79 @@ func (c *Container) GetAutoupdateVersion() (version *registry.Version, err error
 	if err != nil {
 		return
 	}
-	// Update container deployment if greater image version found
-	if latest.Semver.GT(current.Semver) {
-		version = latest
+	// Update container workload if greater image version found
+	if latest == nil || !latest.GT(current) {
+		return
+	}
+	// Pin the image to the exact manifest if the workload asks for it
+	if c.PinDigest() {
+		digest, e := c.repository.GetDigest(latest.Tag)
+		if e != nil {
+			err = fmt.Errorf("cannot resolve digest for '%s:%s': %s", c.repository.Name, latest.Tag, e)
+			return
+		}
+		latest.Digest = digest
 	}
+	version = latest
 	return
 }
 
-// SetRepositoryFrom iterate over registries list to match containers image repository
+// PinDigest checks if the workload asks to pin images to manifest digests on update
+// with `autoupdate_pin_digest` annotation
+func (c *Container) PinDigest() bool {
+	return c.getBoolAnnotation("autoupdate_pin_digest")
+}
+
+// getBoolAnnotation returns a boolean value of the workload annotation, false if it is not set or invalid
+func (c *Container) getBoolAnnotation(key string) bool {
+	value, ok := c.workload.GetAnnotations()[key]
+	if !ok {
+		return false
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		log.Errorf("workload=%s invalid %s annotation: %s", c.GetWorkloadName(), key, err)
+		return false
+	}
+	return b
+}
+
+// SetRepositoryFrom iterate over registries list to match containers image repository.
+// Credentials of the registry are looked up with default credential providers if there are none in the list,
+// anonymous access is used if no provider has them.
 func (c *Container) SetRepositoryFrom(registries *registry.RegistryList) error {
-	image := c.GetImageName()
-	// Choose a registry for container by the name
-	for _, r := range registries.Items {
-		if strings.HasPrefix(image, r.Name+"/") {
-			c.repository = registry.NewRepository(image, r)
-			return nil
-		}
+	ref, err := c.GetImageReference()
+	if err != nil {
+		return err
 	}
-	if defaultRegistry, err := registries.Get("default"); err == nil {
-		c.repository = registry.NewRepository(image, defaultRegistry)
-		return nil
+	// Choose a registry for container by the image domain
+	r, from, err := registries.Lookup(ref.Domain)
+	if err != nil {
+		return fmt.Errorf("cannot match registry for container '%s': %s", c.GetName(), err)
 	}
-	return fmt.Errorf("cannot match registry for container '%s'", c.GetName())
+	log.Infof("workload=%s container=%s registry '%s' credentials from %s",
+		c.GetWorkloadName(), c.GetName(), r.Name, from)
+	c.repository = registry.NewRepository(ref, r)
+
+	// Choose a tag source if the registry API is not the one to use
+	sourceName := c.workload.GetAnnotations()["autoupdate_source_"+c.GetName()]
+	source, err := registry.GetSource(sourceName)
+	if err != nil {
+		return fmt.Errorf("container '%s': %s", c.GetName(), err)
+	}
+	c.repository.Source = source
+
+	scheme, err := c.GetVersionScheme()
+	if err != nil {
+		return fmt.Errorf("container '%s': %s", c.GetName(), err)
+	}
+	c.repository.Scheme = scheme
+	return nil
 }
 
-// NewList list containers to check for updates
+// NewList list containers of workloads of `DefaultKinds` to check for updates
 func NewList(k *client.Client, namespace string) (containers *ContainerList, err error) {
-	// List all deployments lebled with `autoupdate`
+	// List all workloads lebled with `autoupdate`
 	selector, err := labels.Parse("autoupdate")
 	if err != nil {
 		return
@@ -125,47 +368,63 @@ func NewList(k *client.Client, namespace string) (containers *ContainerList, err
 	opts := api.ListOptions{
 		LabelSelector: selector,
 	}
-	deployments, err := k.Deployments(namespace).List(opts)
+	workloads, err := ListWorkloads(k, namespace, DefaultKinds, opts)
 	if err != nil {
 		return
 	}
 
-	// Iterate over the list of deployments to get a list of containers
+	// Iterate over the list of workloads to get a list of containers
 	containers = new(ContainerList)
-	for _, d := range deployments.Items {
-		// Get deployment spec registries
-		registries, e := registry.GetRegistries(k, &d)
-		if e != nil {
-			err = e
-			return
+	for _, w := range workloads {
+		// Skip the workload if its updater config is invalid, an update without the config could go too far
+		errs := validateWorkload(w)
+		hooks, hookErrs := getBeforeUpdateJobs(k, w)
+		if errs = append(errs, hookErrs...); len(errs) > 0 {
+			invalid := &ConfigError{Workload: w, Errors: errs}
+			log.Errorln(invalid.Error())
+			containers.Invalid = append(containers.Invalid, invalid)
+			continue
 		}
 
-		// Get deployment annotations to use as config for updater
-		annotations := d.GetAnnotations()
+		// Get workload spec registries
+		registries, e := registry.GetRegistries(k, w.GetNamespace(), w.GetTemplate())
+		if e != nil {
+			log.Errorf("workload=%s cannot get registries: %s", WorkloadName(w), e)
+			containers.Failed = append(containers.Failed, &Result{
+				Workload: WorkloadName(w),
+				Status:   StatusFailed,
+				Reason:   fmt.Sprintf("cannot get registries: %s", e),
+			})
+			continue
+		}
 
-		// Iterate over pod containers to get update targets
-		for _, c := range d.Spec.Template.Spec.Containers {
+		// Iterate over pod containers and init containers to get update targets,
+		// init containers in lockstep are updated with their main container
+		spec := w.GetTemplate().Spec
+		for i, c := range allContainers(spec) {
 			var container = &Container{
-				container:  c,
-				deployment: d,
+				container: c,
+				workload:  w,
+				init:      i < len(spec.InitContainers),
+			}
+			if main := container.LockstepWith(); main != "" {
+				log.Debugf("workload=%s container=%s in lockstep with container %s",
+					container.GetWorkloadName(), container.GetName(), main)
+				continue
 			}
 
 			if err := container.SetRepositoryFrom(registries); err != nil {
 				log.Errorln(err.Error())
+				containers.Failed = append(containers.Failed, container.Result(StatusFailed, err.Error()))
 				continue
 			}
-			log.Debugf("deployment=%s container=%s use '%s' repository",
-				container.GetDeploymentName(), container.GetName(), container.repository.Name)
-
-			hook_key := "before_autoupdate_" + c.Name
-			hook_name, ok := annotations[hook_key]
-			if ok {
-				job, e := k.Batch().Jobs(d.Namespace).Get(hook_name)
-				if e == nil {
-					container.beforeUpdate = job
-					log.Debugf("deployment=%s container=%s before update hook: %s",
-						container.GetDeploymentName(), container.GetName(), job.Name)
-				}
+			log.Debugf("workload=%s container=%s use '%s' repository",
+				container.GetWorkloadName(), container.GetName(), container.repository.Name)
+
+			if job, ok := hooks[c.Name]; ok {
+				container.beforeUpdate = job
+				log.Debugf("workload=%s container=%s before update hook: %s",
+					container.GetWorkloadName(), container.GetName(), job.Name)
 			}
 
 			containers.Items = append(containers.Items, container)
@@ -175,85 +434,41 @@ func NewList(k *client.Client, namespace string) (containers *ContainerList, err
 	return
 }
 
-// UpdateDeployment updates Deployment version on the cluster
-func (c *Container) UpdateDeployment(k *client.Client, v registry.Version) (err error) {
-	namespace := c.deployment.Namespace
-
-	// Update image version for container
-	newContainer, err := c.SetImageVersion(v)
-	if err != nil {
-		return err
-	}
-
-	// Perform preupdate hook if exists
-	hook := newContainer.GetBeforeUpdateJob()
-	if hook != nil {
-		// Create a job by hook spec
-		createdJob, e := k.Batch().Jobs(namespace).Create(hook)
-		if e != nil {
-			err = e
-			return
+// getBeforeUpdateJobs returns before update hook jobs by container name from `before_autoupdate_<container>` annotations.
+// A hook job which does not exist is an error.
+func getBeforeUpdateJobs(k *client.Client, w Workload) (jobs map[string]*batch.Job, errs []error) {
+	jobs = make(map[string]*batch.Job)
+	annotations := w.GetAnnotations()
+	for _, c := range allContainers(w.GetTemplate().Spec) {
+		name, ok := annotations["before_autoupdate_"+c.Name]
+		if !ok || name == "" {
+			continue
 		}
-		job_name := createdJob.GetName()
-		log.Debugln("before update hook job created with name", job_name)
-
-		// Defer job delete
-		defer func() {
-			if e := util.DeletePodsInJob(k, createdJob); e != nil {
-				log.Errorf("could not delete pods related to job: %s", e.Error())
-			}
-			deleteOptions := &api.DeleteOptions{}
-			if e := k.Batch().Jobs(namespace).Delete(job_name, deleteOptions); e != nil {
-				log.Errorf("could not cleanup job: %s", e.Error())
-			} else {
-				log.Debugf("job %s deleted", job_name)
-			}
-		}()
-
-		// Wait the job to complete
-		for {
-			runningJob, e := k.Batch().Jobs(namespace).Get(job_name)
-			if e != nil {
-				err = e
-				return
-			}
-			if runningJob.Status.Failed > 0 {
-				err = fmt.Errorf("job %s failed")
-				return
-			}
-			if runningJob.Status.Succeeded > 0 {
-				break
-			}
-			time.Sleep(time.Second * 3)
+		job, err := k.Batch().Jobs(w.GetNamespace()).Get(name)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("container '%s': before update hook job '%s': %s", c.Name, name, err))
+			continue
 		}
+		jobs[c.Name] = job
 	}
-
-	_, err = k.Deployments(namespace).Update(&newContainer.deployment)
-	// TODO: what to do with the new deployment? Update our memory store?
-
 	return
 }
 
-// GetBeforeUpdateJob returns configuration for a job to run before deployment update.
+// Result returns a result of the container check with the status and the reason
+func (c *Container) Result(status, reason string) *Result {
+	return &Result{
+		Workload:  c.GetWorkloadName(),
+		Container: c.GetName(),
+		Status:    status,
+		Reason:    reason,
+	}
+}
+
+// GetBeforeUpdateJob returns configuration for a job to run before workload update.
 // It copies the original job and fix it's container image version.
 func (c *Container) GetBeforeUpdateJob() (job *batch.Job) {
 	if c.beforeUpdate == nil {
 		return
 	}
-	hook := c.beforeUpdate
-
-	job = &batch.Job{}
-	job.Spec.Template.Spec = hook.Spec.Template.Spec
-	for i, jobContainer := range job.Spec.Template.Spec.Containers {
-		jobImage := strings.SplitN(jobContainer.Image, ":", 2)[0]
-		if strings.HasPrefix(c.GetImageName(), jobImage+":") {
-			job.Spec.Template.Spec.Containers[i].Image = c.GetImageName()
-			log.Debugln("update job container image to", c.GetImageName())
-		}
-	}
-
-	genName := c.GetName() + "-" + hook.GetName() + "-"
-	job.ObjectMeta.SetGenerateName(genName)
-
-	return
+	return newBeforeUpdateJob(c.beforeUpdate, []*Container{c})
 }
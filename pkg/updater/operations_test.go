@@ -3,6 +3,7 @@ package updater
 import (
 	// "github.com/sabakaio/k8s-updater/pkg/util"
 	"github.com/sabakaio/k8s-updater/pkg/registry"
+	"github.com/sabakaio/k8s-updater/pkg/registry/registrytest"
 	. "github.com/smartystreets/goconvey/convey"
 	"k8s.io/kubernetes/pkg/api"
 	ext "k8s.io/kubernetes/pkg/apis/extensions"
@@ -14,11 +15,11 @@ func TestContainer(t *testing.T) {
 		Name:  "my-container",
 		Image: "registry.example.com/my-image:1.2.3",
 	}
-	k8sDeployment := ext.Deployment{}
+	k8sDeployment := &ext.Deployment{}
 	k8sDeployment.Spec.Template.Spec.Containers = append(k8sDeployment.Spec.Template.Spec.Containers, k8sContainer)
 	container := Container{
-		container:  k8sContainer,
-		deployment: k8sDeployment,
+		container: k8sContainer,
+		workload:  DeploymentWorkload{k8sDeployment},
 	}
 
 	Convey("Test Container struct", t, func() {
@@ -61,6 +62,39 @@ func TestContainer(t *testing.T) {
 			version, err = container.GetImageVersion()
 			So(err, ShouldNotBeNil)
 			So(version, ShouldBeNil)
+
+			// Registry with a port is not confused with a tag
+			container.container.Image = "localhost:5000/my-image:1.2.0"
+			version, err = container.GetImageVersion()
+			So(err, ShouldBeNil)
+			So(version.Tag, ShouldEqual, "1.2.0")
+
+			container.container.Image = "localhost:5000/my-image"
+			version, err = container.GetImageVersion()
+			So(err, ShouldNotBeNil)
+			So(version, ShouldBeNil)
+
+			// Digest is not a part of a tag
+			container.container.Image = "my-image:1.2.0@sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+			version, err = container.GetImageVersion()
+			So(err, ShouldBeNil)
+			So(version.Tag, ShouldEqual, "1.2.0")
+		})
+
+		Convey("Test version scheme annotation", func() {
+			container.container.Image = "registry.example.com/my-image:2024.06.1"
+			_, err := container.GetImageVersion()
+			So(err, ShouldNotBeNil)
+
+			k8sDeployment.SetAnnotations(map[string]string{"autoupdate_version_scheme_my-container": "calver"})
+			version, err := container.GetImageVersion()
+			So(err, ShouldBeNil)
+			So(version.Numbers, ShouldResemble, []int64{2024, 6, 1})
+
+			k8sDeployment.SetAnnotations(map[string]string{"autoupdate_version_scheme_my-container": "unknown"})
+			_, err = container.GetImageVersion()
+			So(err, ShouldNotBeNil)
+			k8sDeployment.SetAnnotations(nil)
 		})
 
 		Convey("Test update version", func() {
@@ -73,7 +107,290 @@ func TestContainer(t *testing.T) {
 			So(err, ShouldBeNil)
 			expectedImage := "registry.example.com/my-image:1.6.6"
 			So(newContainer.container.Image, ShouldEqual, expectedImage)
-			So(newContainer.deployment.Spec.Template.Spec.Containers[0].Image, ShouldEqual, expectedImage)
+			So(newContainer.workload.GetTemplate().Spec.Containers[0].Image, ShouldEqual, expectedImage)
+
+			// Registry port is kept and digest is dropped
+			container.container.Image = "localhost:5000/my-image:1.2.3@sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+			newContainer, err = container.SetImageVersion(*newVersion)
+			So(err, ShouldBeNil)
+			So(newContainer.container.Image, ShouldEqual, "localhost:5000/my-image:1.6.6")
+
+			// Digest is written if the deployment pins versions
+			k8sDeployment.SetAnnotations(map[string]string{"autoupdate_pin_digest": "true"})
+			pinnedVersion := *newVersion
+			pinnedVersion.Digest = "sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+			newContainer, err = container.SetImageVersion(pinnedVersion)
+			So(err, ShouldBeNil)
+			So(newContainer.container.Image, ShouldEqual, "localhost:5000/my-image:1.6.6@"+pinnedVersion.Digest)
+
+			// Implicit Docker Hub name is kept as it is
+			container.container.Image = "nginx:1.2.3"
+			newContainer, err = container.SetImageVersion(*newVersion)
+			So(err, ShouldBeNil)
+			So(newContainer.container.Image, ShouldEqual, "nginx:1.6.6")
+		})
+	})
+}
+
+func TestFollowDigest(t *testing.T) {
+	digest := "sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+	k8sContainer := api.Container{
+		Name:  "tool",
+		Image: "registry.example.com/tool:latest",
+	}
+
+	Convey("Test following tag by digest", t, func() {
+		k8sDeployment := &ext.Deployment{}
+		k8sDeployment.Spec.Template.Spec.Containers = append(k8sDeployment.Spec.Template.Spec.Containers, k8sContainer)
+		k8sDeployment.SetAnnotations(map[string]string{"autoupdate_follow_digest_tool": "true"})
+		container := Container{
+			container: k8sContainer,
+			workload:  DeploymentWorkload{k8sDeployment},
+		}
+		So(container.FollowDigest(), ShouldBeTrue)
+		version := registry.Version{Tag: "latest", Digest: digest}
+
+		Convey("Digest is recorded in pod template annotation", func() {
+			newContainer, err := container.SetImageVersion(version)
+			So(err, ShouldBeNil)
+			So(newContainer.container.Image, ShouldEqual, "registry.example.com/tool:latest")
+			So(newContainer.workload.GetTemplate().GetAnnotations()["autoupdate_digest_tool"], ShouldEqual, digest)
+		})
+
+		Convey("Digest is pinned if the deployment asks for it", func() {
+			k8sDeployment.SetAnnotations(map[string]string{
+				"autoupdate_follow_digest_tool": "true",
+				"autoupdate_pin_digest":         "true",
+			})
+			newContainer, err := container.SetImageVersion(version)
+			So(err, ShouldBeNil)
+			So(newContainer.container.Image, ShouldEqual, "registry.example.com/tool:latest@"+digest)
+			So(newContainer.workload.GetTemplate().GetAnnotations(), ShouldNotContainKey, "autoupdate_digest_tool")
+		})
+	})
+}
+
+func TestInitContainers(t *testing.T) {
+	Convey("Test init containers", t, func() {
+		k8sDeployment := &ext.Deployment{}
+		k8sDeployment.Spec.Template.Spec.InitContainers = []api.Container{
+			{Name: "migrate", Image: "registry.example.com/app:1.0.0"},
+			{Name: "render", Image: "registry.example.com/render:1.0.0"},
+			{Name: "wait", Image: "busybox:1.25"},
+		}
+		k8sDeployment.Spec.Template.Spec.Containers = []api.Container{
+			{Name: "web", Image: "registry.example.com/app:1.0.0"},
+		}
+		k8sDeployment.SetAnnotations(map[string]string{
+			"autoupdate_lockstep_migrate": "web",
+			"autoupdate_lockstep_render":  "web",
+		})
+		w := DeploymentWorkload{k8sDeployment}
+		web := &Container{container: k8sDeployment.Spec.Template.Spec.Containers[0], workload: w}
+		wait := &Container{container: k8sDeployment.Spec.Template.Spec.InitContainers[2], workload: w, init: true}
+		migrate := &Container{container: k8sDeployment.Spec.Template.Spec.InitContainers[0], workload: w, init: true}
+		digest := "sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+
+		Convey("Init container is updated in its slice", func() {
+			_, err := wait.SetImageVersion(registry.Version{Tag: "1.26"})
+			So(err, ShouldBeNil)
+			So(k8sDeployment.Spec.Template.Spec.InitContainers[2].Image, ShouldEqual, "busybox:1.26")
+			So(k8sDeployment.Spec.Template.Spec.Containers[0].Image, ShouldEqual, "registry.example.com/app:1.0.0")
+		})
+
+		Convey("Init containers in lockstep get the version of the main container", func() {
+			So(migrate.LockstepWith(), ShouldEqual, "web")
+			So(web.LockstepWith(), ShouldEqual, "")
+			So(wait.LockstepWith(), ShouldEqual, "")
+
+			k8sDeployment.Annotations["autoupdate_pin_digest"] = "true"
+			_, err := web.SetImageVersion(registry.Version{Tag: "1.1.0", Digest: digest})
+			So(err, ShouldBeNil)
+			initContainers := k8sDeployment.Spec.Template.Spec.InitContainers
+			So(initContainers[0].Image, ShouldEqual, "registry.example.com/app:1.1.0@"+digest)
+			// The tag could be missing in the repository of another image
+			So(initContainers[1].Image, ShouldEqual, "registry.example.com/render:1.0.0")
+			So(initContainers[2].Image, ShouldEqual, "busybox:1.25")
+		})
+	})
+}
+
+func TestAutoupdate(t *testing.T) {
+	Convey("Test autoupdate against fake registry", t, func() {
+		fake := registrytest.NewRegistry()
+		defer fake.Close()
+		fake.AddTags("app", "1.0.0", "1.1.0", "2.0.0", "latest")
+		So(registry.SetConfigs([]*registry.Config{{Host: fake.Host(), Scheme: "http"}}), ShouldBeNil)
+		defer registry.SetConfigs(nil)
+
+		k8sContainer := api.Container{
+			Name:  "web",
+			Image: fake.Host() + "/app:1.0.0",
+		}
+		k8sDeployment := &ext.Deployment{}
+		k8sDeployment.Spec.Template.Spec.Containers = append(k8sDeployment.Spec.Template.Spec.Containers, k8sContainer)
+		container := &Container{
+			container: k8sContainer,
+			workload:  DeploymentWorkload{k8sDeployment},
+		}
+		So(container.SetRepositoryFrom(new(registry.RegistryList)), ShouldBeNil)
+
+		Convey("Update to the latest version", func() {
+			version, err := container.GetAutoupdateVersion()
+			So(err, ShouldBeNil)
+			So(version.Tag, ShouldEqual, "2.0.0")
+			newContainer, err := container.SetImageVersion(*version)
+			So(err, ShouldBeNil)
+			So(newContainer.workload.GetTemplate().Spec.Containers[0].Image, ShouldEqual, fake.Host()+"/app:2.0.0")
+		})
+
+		Convey("Update in versions range", func() {
+			k8sDeployment.SetAnnotations(map[string]string{"autoupdate_version_range_web": "<2.0.0"})
+			version, err := container.GetAutoupdateVersion()
+			So(err, ShouldBeNil)
+			So(version.Tag, ShouldEqual, "1.1.0")
+		})
+
+		Convey("Update with relative policies", func() {
+			fake.AddTags("app", "1.0.1")
+			policies := map[string]string{"patch": "1.0.1", "minor": "1.1.0", "major": "2.0.0"}
+			for policy, expected := range policies {
+				k8sDeployment.SetAnnotations(map[string]string{"autoupdate_policy_web": policy})
+				version, err := container.GetAutoupdateVersion()
+				So(err, ShouldBeNil)
+				So(version.Tag, ShouldEqual, expected)
+			}
+
+			// Combined with the absolute range
+			k8sDeployment.SetAnnotations(map[string]string{
+				"autoupdate_policy_web":        "minor",
+				"autoupdate_version_range_web": "<1.1.0",
+			})
+			version, err := container.GetAutoupdateVersion()
+			So(err, ShouldBeNil)
+			So(version.Tag, ShouldEqual, "1.0.1")
+
+			// Report only
+			k8sDeployment.SetAnnotations(map[string]string{"autoupdate_policy_web": "none"})
+			version, err = container.GetAutoupdateVersion()
+			So(err, ShouldBeNil)
+			So(version, ShouldBeNil)
+			So(container.ReportOnly(), ShouldBeTrue)
+			// The available version is reported
+			version, err = container.GetAvailableVersion()
+			So(err, ShouldBeNil)
+			So(version.Tag, ShouldEqual, "2.0.0")
+
+			k8sDeployment.SetAnnotations(map[string]string{"autoupdate_policy_web": "latest"})
+			_, err = container.GetAutoupdateVersion()
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("Update in npm style versions range", func() {
+			k8sDeployment.SetAnnotations(map[string]string{"autoupdate_version_range_web": "^1.0"})
+			version, err := container.GetAutoupdateVersion()
+			So(err, ShouldBeNil)
+			So(version.Tag, ShouldEqual, "1.1.0")
+
+			// Invalid range is an error, not an update without a filter
+			k8sDeployment.SetAnnotations(map[string]string{"autoupdate_version_range_web": "^1.0 ||"})
+			version, err = container.GetAutoupdateVersion()
+			So(err, ShouldNotBeNil)
+			So(version, ShouldBeNil)
+		})
+
+		Convey("Nothing to update", func() {
+			container.container.Image = fake.Host() + "/app:2.0.0"
+			version, err := container.GetAutoupdateVersion()
+			So(err, ShouldBeNil)
+			So(version, ShouldBeNil)
+		})
+
+		Convey("Update pinned to digest", func() {
+			k8sDeployment.SetAnnotations(map[string]string{"autoupdate_pin_digest": "true"})
+			version, err := container.GetAutoupdateVersion()
+			So(err, ShouldBeNil)
+			So(version.Digest, ShouldEqual, fake.GetDigest("app", "2.0.0"))
+		})
+
+		Convey("Follow the tag by digest", func() {
+			container.container.Image = fake.Host() + "/app:latest"
+			k8sDeployment.SetAnnotations(map[string]string{"autoupdate_follow_digest_web": "true"})
+			version, err := container.GetAutoupdateVersion()
+			So(err, ShouldBeNil)
+			So(version.Tag, ShouldEqual, "latest")
+			So(version.Digest, ShouldEqual, fake.GetDigest("app", "latest"))
+
+			// Nothing to update once the digest is recorded
+			container.SetImageVersion(*version)
+			version, err = container.GetAutoupdateVersion()
+			So(err, ShouldBeNil)
+			So(version, ShouldBeNil)
+		})
+
+		Convey("Prereleases are skipped on the stable channel", func() {
+			fake.AddTags("app", "2.1.0-rc.1", "3.0.0-beta.1")
+			version, err := container.GetAutoupdateVersion()
+			So(err, ShouldBeNil)
+			So(version.Tag, ShouldEqual, "2.0.0")
+
+			k8sDeployment.SetAnnotations(map[string]string{"autoupdate_channel_web": "beta"})
+			container.container.Image = fake.Host() + "/app:2.0.0"
+			version, err = container.GetAutoupdateVersion()
+			So(err, ShouldBeNil)
+			So(version.Tag, ShouldEqual, "3.0.0-beta.1")
+
+			// A final release goes first, even if its core version differs from the latest prerelease `2.1.0-rc.1`
+			k8sDeployment.SetAnnotations(map[string]string{"autoupdate_channel_web": "rc"})
+			container.container.Image = fake.Host() + "/app:1.0.0"
+			version, err = container.GetAutoupdateVersion()
+			So(err, ShouldBeNil)
+			So(version.Tag, ShouldEqual, "2.0.0")
+
+			k8sDeployment.SetAnnotations(map[string]string{
+				"autoupdate_channel_web":      "rc",
+				"autoupdate_prefer_final_web": "false",
+			})
+			version, err = container.GetAutoupdateVersion()
+			So(err, ShouldBeNil)
+			So(version.Tag, ShouldEqual, "2.1.0-rc.1")
+		})
+
+		Convey("Update keeping the tag variant", func() {
+			fake.AddTags("app", "1.0.0-alpine", "1.2.0-alpine", "1.3.0-gpu")
+			container.container.Image = fake.Host() + "/app:1.0.0-alpine"
+			k8sDeployment.SetAnnotations(map[string]string{"autoupdate_tag_pattern_web": `^(?P<version>\d+\.\d+\.\d+)-alpine$`})
+			So(container.SetRepositoryFrom(new(registry.RegistryList)), ShouldBeNil)
+
+			current, err := container.GetImageVersion()
+			So(err, ShouldBeNil)
+			So(current.Tag, ShouldEqual, "1.0.0-alpine")
+
+			version, err := container.GetAutoupdateVersion()
+			So(err, ShouldBeNil)
+			So(version.Tag, ShouldEqual, "1.2.0-alpine")
+			newContainer, err := container.SetImageVersion(*version)
+			So(err, ShouldBeNil)
+			So(newContainer.container.Image, ShouldEqual, fake.Host()+"/app:1.2.0-alpine")
+		})
+
+		Convey("Build numbers of other prefixes are not updates", func() {
+			fake.AddTags("app", "build-4812", "build-4900", "pr-9999", "sha-abc12345")
+			container.container.Image = fake.Host() + "/app:build-4812"
+			k8sDeployment.SetAnnotations(map[string]string{"autoupdate_version_scheme_web": "integer"})
+			So(container.SetRepositoryFrom(new(registry.RegistryList)), ShouldBeNil)
+
+			version, err := container.GetAutoupdateVersion()
+			So(err, ShouldBeNil)
+			So(version.Tag, ShouldEqual, "build-4900")
+		})
+
+		Convey("Track the floating tag", func() {
+			fake.Retag("app", "stable", "1.1.0")
+			k8sDeployment.SetAnnotations(map[string]string{"autoupdate_track_web": "stable"})
+			version, err := container.GetAutoupdateVersion()
+			So(err, ShouldBeNil)
+			So(version.Tag, ShouldEqual, "1.1.0")
 		})
 	})
 }
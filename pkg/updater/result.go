@@ -0,0 +1,82 @@
+package updater
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// Statuses of a container check
+const (
+	StatusUpdated = "updated"
+	// StatusAvailable is a version which is not updated to in a dry run, or with `none` update policy
+	StatusAvailable = "available"
+	StatusUpToDate  = "up-to-date"
+	StatusSkipped   = "skipped"
+	StatusFailed    = "failed"
+)
+
+var statuses = []string{StatusUpdated, StatusAvailable, StatusUpToDate, StatusSkipped, StatusFailed}
+
+// Result is an outcome of a container check. Container is empty if the whole workload is skipped or failed.
+type Result struct {
+	Workload  string
+	Container string
+	Status    string
+	// Version is a version the container is updated to, or could be updated to if it is available
+	Version string
+	Reason  string
+}
+
+func (r *Result) String() string {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "workload=%s", r.Workload)
+	if r.Container != "" {
+		fmt.Fprintf(&b, " container=%s", r.Container)
+	}
+	b.WriteString(" " + r.Status)
+	if r.Version != "" {
+		fmt.Fprintf(&b, " version=%s", r.Version)
+	}
+	if r.Reason != "" {
+		b.WriteString(": " + r.Reason)
+	}
+	return b.String()
+}
+
+// Report collects results of a run
+type Report struct {
+	Results []*Result
+}
+
+// Add adds the result to the report
+func (r *Report) Add(result *Result) {
+	r.Results = append(r.Results, result)
+}
+
+// Count returns a number of results with the status
+func (r *Report) Count(status string) (n int) {
+	for _, result := range r.Results {
+		if result.Status == status {
+			n++
+		}
+	}
+	return
+}
+
+// Failed checks if some results are failed, or skipped if skips are failures too
+func (r *Report) Failed(skipsAreFailures bool) bool {
+	return r.Count(StatusFailed) > 0 || (skipsAreFailures && r.Count(StatusSkipped) > 0)
+}
+
+// Summary returns numbers of results by status, e.g. `3 results: 1 updated, 0 available, 2 up-to-date, 0 skipped, 0 failed`
+func (r *Report) Summary() string {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "%d results:", len(r.Results))
+	for i, status := range statuses {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		fmt.Fprintf(&b, " %d %s", r.Count(status), status)
+	}
+	return b.String()
+}
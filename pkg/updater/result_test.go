@@ -0,0 +1,33 @@
+package updater
+
+import (
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+)
+
+func TestReport(t *testing.T) {
+	Convey("Test run report", t, func() {
+		report := new(Report)
+		So(report.Failed(true), ShouldBeFalse)
+		So(report.Summary(), ShouldEqual, "0 results: 0 updated, 0 available, 0 up-to-date, 0 skipped, 0 failed")
+
+		report.Add(&Result{Workload: "deployment/app", Container: "web", Status: StatusUpdated, Version: "1.2.0"})
+		report.Add(&Result{Workload: "deployment/app", Container: "worker", Status: StatusUpToDate})
+		report.Add(&Result{Workload: "deployment/app", Container: "db", Status: StatusAvailable, Version: "9.6.1", Reason: "dry run"})
+		// Available versions are not skips
+		So(report.Failed(true), ShouldBeFalse)
+
+		report.Add(&Result{Workload: "daemonset/agent", Container: "agent", Status: StatusSkipped, Reason: "registry is unavailable"})
+		So(report.Failed(false), ShouldBeFalse)
+		So(report.Failed(true), ShouldBeTrue)
+
+		report.Add(&Result{Workload: "deployment/db", Status: StatusFailed, Reason: "invalid config"})
+		So(report.Failed(false), ShouldBeTrue)
+		So(report.Summary(), ShouldEqual, "5 results: 1 updated, 1 available, 1 up-to-date, 1 skipped, 1 failed")
+
+		So(report.Results[0].String(), ShouldEqual, "workload=deployment/app container=web updated version=1.2.0")
+		So(report.Results[2].String(), ShouldEqual, "workload=deployment/app container=db available version=9.6.1: dry run")
+		So(report.Results[3].String(), ShouldEqual, "workload=daemonset/agent container=agent skipped: registry is unavailable")
+		So(report.Results[4].String(), ShouldEqual, "workload=deployment/db failed: invalid config")
+	})
+}
@@ -4,13 +4,14 @@ import (
 	"github.com/sabakaio/k8s-updater/pkg/registry"
 	"k8s.io/kubernetes/pkg/api"
 	"k8s.io/kubernetes/pkg/apis/batch"
-	ext "k8s.io/kubernetes/pkg/apis/extensions"
 )
 
-// Container holds a container to check for version update linked with `Deployment`
+// Container holds a container to check for version update linked with its `Workload`.
+// Containers of a workload share it, so changes of its pod template are saved together.
 type Container struct {
 	container    api.Container
-	deployment   ext.Deployment
+	workload     Workload
+	init         bool
 	repository   *registry.Repository
 	beforeUpdate *batch.Job
 }
@@ -18,4 +19,8 @@ type Container struct {
 // ContainerList is a list of containers to check for version update
 type ContainerList struct {
 	Items []*Container
+	// Invalid are workloads skipped because of invalid updater config
+	Invalid []*ConfigError
+	// Failed are results of workloads and containers which could not be checked
+	Failed []*Result
 }
@@ -0,0 +1,339 @@
+package updater
+
+import (
+	"encoding/json"
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"github.com/sabakaio/k8s-updater/pkg/reference"
+	"github.com/sabakaio/k8s-updater/pkg/registry"
+	"github.com/sabakaio/k8s-updater/pkg/util"
+	"k8s.io/kubernetes/pkg/api"
+	"k8s.io/kubernetes/pkg/api/errors"
+	"k8s.io/kubernetes/pkg/apis/batch"
+	client "k8s.io/kubernetes/pkg/client/unversioned"
+	"time"
+)
+
+// DefaultUpdateRetries is a number of retries of a workload write failed with a conflict
+var DefaultUpdateRetries = 3
+
+// Change is a new version of a container
+type Change struct {
+	Container *Container
+	Version   registry.Version
+}
+
+// WorkloadUpdate holds changes of containers of one workload, they are saved with a single write
+type WorkloadUpdate struct {
+	Workload Workload
+	Changes  []Change
+}
+
+// UpdateList groups container changes by workload, in the order workloads get their first change
+type UpdateList struct {
+	Items []*WorkloadUpdate
+	index map[Workload]*WorkloadUpdate
+}
+
+// Add adds a new version of the container to the update of its workload
+// NOTE a version is passed by the value to avoid nil pointer errors
+func (l *UpdateList) Add(c *Container, v registry.Version) {
+	if l.index == nil {
+		l.index = make(map[Workload]*WorkloadUpdate)
+	}
+	u, ok := l.index[c.workload]
+	if !ok {
+		u = &WorkloadUpdate{Workload: c.workload}
+		l.index[c.workload] = u
+		l.Items = append(l.Items, u)
+	}
+	u.Changes = append(u.Changes, Change{Container: c, Version: v})
+}
+
+// GetWorkloadName returns the workload kind and name, e.g. `deployment/web`
+func (u *WorkloadUpdate) GetWorkloadName() string {
+	return WorkloadName(u.Workload)
+}
+
+// Apply sets new versions of the containers, runs before update hooks of the changed containers
+// once per hook job, and writes the changes. The workload is not changed if a hook fails.
+// Changes are written with `write`, which is retried up to `DefaultUpdateRetries` times on a conflict.
+// A result is returned for each change, all of them are failed if the error is returned.
+func (u *WorkloadUpdate) Apply(k *client.Client) (results []*Result, err error) {
+	defer func() {
+		if err != nil {
+			results = u.failed(err)
+		}
+	}()
+	for _, change := range u.Changes {
+		if _, err = change.Container.SetImageVersion(change.Version); err != nil {
+			return
+		}
+	}
+	for _, job := range u.GetBeforeUpdateJobs() {
+		if err = runJob(k, u.Workload.GetNamespace(), job); err != nil {
+			return
+		}
+	}
+	for attempt := 0; ; attempt++ {
+		results, err = u.write(k)
+		if !errors.IsConflict(err) {
+			return
+		}
+		if attempt >= DefaultUpdateRetries {
+			err = fmt.Errorf("%s is changed concurrently, gave up after %d retries: %s", u.GetWorkloadName(), attempt, err)
+			return
+		}
+		log.Warnf("workload=%s changed concurrently, retrying: %s", u.GetWorkloadName(), err)
+	}
+}
+
+// failed returns a failed result of each change
+func (u *WorkloadUpdate) failed(err error) (results []*Result) {
+	for _, change := range u.Changes {
+		result := change.Container.Result(StatusFailed, err.Error())
+		result.Version = change.Version.Tag
+		results = append(results, result)
+	}
+	return
+}
+
+// write re-fetches the workload, sets versions which are still new to it and allowed by its annotations, and patches the changed
+// images and pod template annotations only. The patch holds the re-fetched resource version, so it fails
+// with a conflict if the workload is changed in between. Init containers are kept in a pod template
+// annotation by the Kubernetes API, so their changes are written with an update of the re-fetched workload.
+func (u *WorkloadUpdate) write(k *client.Client) (results []*Result, err error) {
+	if err = u.Workload.Reload(k); err != nil {
+		return
+	}
+	template := u.Workload.GetTemplate()
+	before := copyTemplate(template)
+	if results, err = u.setVersions(); err != nil {
+		return
+	}
+
+	patch, initChanged := templatePatch(&before, template)
+	if patch == nil {
+		log.Debugf("workload=%s nothing to write", u.GetWorkloadName())
+		for _, result := range results {
+			if result.Status == StatusUpdated {
+				result.Status, result.Reason = StatusUpToDate, "nothing to write"
+			}
+		}
+		return
+	}
+	if initChanged {
+		err = u.Workload.Update(k)
+		return
+	}
+	data, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{"resourceVersion": u.Workload.GetResourceVersion()},
+		"spec":     map[string]interface{}{"template": patch},
+	})
+	if err != nil {
+		return
+	}
+	log.Debugf("workload=%s patch %s", u.GetWorkloadName(), data)
+	err = u.Workload.Patch(k, data)
+	return
+}
+
+// setVersions re-evaluates annotations of the re-fetched workload and sets versions which are still new to
+// the containers and allowed by their annotations. Annotations could be changed while hooks run,
+// e.g. the update policy is set to `none`, invalid annotations fail the update.
+// A result is returned for each change: updated, up-to-date if the container is at the same or a newer version,
+// available if updates are turned off, or skipped if the version is not allowed anymore.
+func (u *WorkloadUpdate) setVersions() (results []*Result, err error) {
+	if errs := validateWorkload(u.Workload); len(errs) > 0 {
+		err = &ConfigError{Workload: u.Workload, Errors: errs}
+		return
+	}
+	for _, change := range u.Changes {
+		c := change.Container
+		if !c.refresh() {
+			err = fmt.Errorf("container '%s' is not in %s anymore", c.GetName(), u.GetWorkloadName())
+			return
+		}
+		var result *Result
+		if change.stale() {
+			log.Infof("workload=%s container=%s is at %s already, not updated to %s",
+				u.GetWorkloadName(), c.GetName(), c.GetImageName(), change.Version.Tag)
+			result = c.Result(StatusUpToDate, fmt.Sprintf("at %s already", c.GetImageName()))
+		} else if c.ReportOnly() {
+			log.Infof("workload=%s container=%s version %s is available, not updated by policy '%s'",
+				u.GetWorkloadName(), c.GetName(), change.Version.String(), c.GetUpdatePolicy())
+			result = c.Result(StatusAvailable, fmt.Sprintf("update policy '%s'", c.GetUpdatePolicy()))
+			result.Version = change.Version.String()
+		} else if e := c.qualifies(change.Version); e != nil {
+			log.Warnf("workload=%s container=%s not updated to %s: %s",
+				u.GetWorkloadName(), c.GetName(), change.Version.Tag, e)
+			result = c.Result(StatusSkipped, e.Error())
+			result.Version = change.Version.Tag
+		} else {
+			if _, err = c.SetImageVersion(change.Version); err != nil {
+				return
+			}
+			result = c.Result(StatusUpdated, "")
+			result.Version = change.Version.Tag
+		}
+		results = append(results, result)
+	}
+	return
+}
+
+// stale checks if the re-fetched container does not need the change: it runs another tag
+// which is not older than the new version, e.g. it was updated by someone else
+func (change Change) stale() bool {
+	current, err := change.Container.GetImageVersion()
+	if err != nil || current.Tag == change.Version.Tag {
+		return false
+	}
+	return !change.Version.GT(current)
+}
+
+// refresh takes the container from the workload pod template, false if it is not there anymore
+func (c *Container) refresh() bool {
+	for _, pc := range c.podContainers() {
+		if pc.Name == c.GetName() {
+			c.container = pc
+			return true
+		}
+	}
+	return false
+}
+
+// copyTemplate copies containers and annotations of the pod template to compare them after changes
+func copyTemplate(template *api.PodTemplateSpec) (c api.PodTemplateSpec) {
+	c.Annotations = make(map[string]string)
+	for key, value := range template.Annotations {
+		c.Annotations[key] = value
+	}
+	c.Spec.Containers = append([]api.Container(nil), template.Spec.Containers...)
+	c.Spec.InitContainers = append([]api.Container(nil), template.Spec.InitContainers...)
+	return
+}
+
+// templatePatch returns a strategic merge patch of the pod template with changed container images
+// and annotations, nil if nothing is changed. Containers are merged by name.
+func templatePatch(before, after *api.PodTemplateSpec) (patch map[string]interface{}, initChanged bool) {
+	var containers []map[string]string
+	for i, c := range after.Spec.Containers {
+		if i < len(before.Spec.Containers) && before.Spec.Containers[i].Image != c.Image {
+			containers = append(containers, map[string]string{"name": c.Name, "image": c.Image})
+		}
+	}
+	for i, c := range after.Spec.InitContainers {
+		if i < len(before.Spec.InitContainers) && before.Spec.InitContainers[i].Image != c.Image {
+			initChanged = true
+		}
+	}
+	annotations := make(map[string]string)
+	for key, value := range after.Annotations {
+		if before.Annotations[key] != value {
+			annotations[key] = value
+		}
+	}
+	if len(containers) == 0 && len(annotations) == 0 && !initChanged {
+		return
+	}
+
+	patch = make(map[string]interface{})
+	if len(containers) > 0 {
+		patch["spec"] = map[string]interface{}{"containers": containers}
+	}
+	if len(annotations) > 0 {
+		patch["metadata"] = map[string]interface{}{"annotations": annotations}
+	}
+	return
+}
+
+// GetBeforeUpdateJobs returns jobs to run before the workload update. Containers sharing a hook job
+// run it once, with images of all of them.
+func (u *WorkloadUpdate) GetBeforeUpdateJobs() (jobs []*batch.Job) {
+	var hooks []*batch.Job
+	containers := make(map[string][]*Container)
+	for _, change := range u.Changes {
+		hook := change.Container.beforeUpdate
+		if hook == nil {
+			continue
+		}
+		if _, ok := containers[hook.Name]; !ok {
+			hooks = append(hooks, hook)
+		}
+		containers[hook.Name] = append(containers[hook.Name], change.Container)
+	}
+	for _, hook := range hooks {
+		jobs = append(jobs, newBeforeUpdateJob(hook, containers[hook.Name]))
+	}
+	return
+}
+
+// newBeforeUpdateJob copies the hook job spec and sets images of the containers to its containers of the same image
+func newBeforeUpdateJob(hook *batch.Job, containers []*Container) (job *batch.Job) {
+	job = &batch.Job{}
+	job.Spec.Template.Spec = hook.Spec.Template.Spec
+	// Containers are copied not to change the hook job
+	job.Spec.Template.Spec.Containers = append([]api.Container(nil), hook.Spec.Template.Spec.Containers...)
+	for _, c := range containers {
+		ref, err := c.GetImageReference()
+		if err != nil {
+			log.Errorln(err)
+			continue
+		}
+		for i, jobContainer := range job.Spec.Template.Spec.Containers {
+			jobRef, e := reference.Parse(jobContainer.Image)
+			if e != nil {
+				continue
+			}
+			if ref.SameName(jobRef) {
+				job.Spec.Template.Spec.Containers[i].Image = c.GetImageName()
+				log.Debugln("update job container image to", c.GetImageName())
+			}
+		}
+	}
+
+	genName := containers[0].GetName() + "-" + hook.GetName() + "-"
+	job.ObjectMeta.SetGenerateName(genName)
+	return
+}
+
+// runJob creates the job, waits for it to complete and deletes it with its pods
+func runJob(k *client.Client, namespace string, job *batch.Job) (err error) {
+	// Create a job by hook spec
+	createdJob, err := k.Batch().Jobs(namespace).Create(job)
+	if err != nil {
+		return
+	}
+	job_name := createdJob.GetName()
+	log.Debugln("before update hook job created with name", job_name)
+
+	// Defer job delete
+	defer func() {
+		if e := util.DeletePodsInJob(k, createdJob); e != nil {
+			log.Errorf("could not delete pods related to job: %s", e.Error())
+		}
+		deleteOptions := &api.DeleteOptions{}
+		if e := k.Batch().Jobs(namespace).Delete(job_name, deleteOptions); e != nil {
+			log.Errorf("could not cleanup job: %s", e.Error())
+		} else {
+			log.Debugf("job %s deleted", job_name)
+		}
+	}()
+
+	// Wait the job to complete
+	for {
+		runningJob, e := k.Batch().Jobs(namespace).Get(job_name)
+		if e != nil {
+			err = e
+			return
+		}
+		if runningJob.Status.Failed > 0 {
+			err = fmt.Errorf("job %s failed", job_name)
+			return
+		}
+		if runningJob.Status.Succeeded > 0 {
+			return
+		}
+		time.Sleep(time.Second * 3)
+	}
+}
@@ -0,0 +1,198 @@
+package updater
+
+import (
+	"github.com/sabakaio/k8s-updater/pkg/registry"
+	. "github.com/smartystreets/goconvey/convey"
+	"k8s.io/kubernetes/pkg/api"
+	"k8s.io/kubernetes/pkg/apis/batch"
+	ext "k8s.io/kubernetes/pkg/apis/extensions"
+	"testing"
+)
+
+func TestUpdateList(t *testing.T) {
+	Convey("Test updates grouped by workload", t, func() {
+		newWorkload := func(name string) (Workload, []*Container) {
+			d := &ext.Deployment{}
+			d.Name = name
+			d.Spec.Template.Spec.Containers = []api.Container{
+				{Name: "web", Image: "registry.example.com/app:1.0.0"},
+				{Name: "worker", Image: "registry.example.com/worker:1.0.0"},
+			}
+			w := DeploymentWorkload{d}
+			var containers []*Container
+			for _, c := range d.Spec.Template.Spec.Containers {
+				containers = append(containers, &Container{container: c, workload: w})
+			}
+			return w, containers
+		}
+		a, aContainers := newWorkload("a")
+		b, bContainers := newWorkload("b")
+
+		Convey("Changes of a workload are grouped", func() {
+			updates := new(UpdateList)
+			updates.Add(aContainers[0], registry.Version{Tag: "1.1.0"})
+			updates.Add(bContainers[1], registry.Version{Tag: "2.0.0"})
+			updates.Add(aContainers[1], registry.Version{Tag: "1.2.0"})
+			So(updates.Items, ShouldHaveLength, 2)
+			So(updates.Items[0].Workload == a, ShouldBeTrue)
+			So(updates.Items[0].Changes, ShouldHaveLength, 2)
+			So(updates.Items[0].GetWorkloadName(), ShouldEqual, "deployment/a")
+			So(updates.Items[1].Workload == b, ShouldBeTrue)
+			So(updates.Items[1].Changes, ShouldHaveLength, 1)
+		})
+
+		Convey("A hook job shared by containers runs once with their images", func() {
+			hook := &batch.Job{}
+			hook.Name = "migrate"
+			hook.Spec.Template.Spec.Containers = []api.Container{
+				{Name: "app", Image: "registry.example.com/app:1.0.0"},
+				{Name: "worker", Image: "registry.example.com/worker:1.0.0"},
+			}
+			other := &batch.Job{}
+			other.Name = "notify"
+			other.Spec.Template.Spec.Containers = []api.Container{
+				{Name: "notify", Image: "curl:7"},
+			}
+			aContainers[0].beforeUpdate = hook
+			aContainers[1].beforeUpdate = hook
+
+			updates := new(UpdateList)
+			updates.Add(aContainers[0], registry.Version{Tag: "1.1.0"})
+			updates.Add(aContainers[1], registry.Version{Tag: "1.2.0"})
+			for _, change := range updates.Items[0].Changes {
+				_, err := change.Container.SetImageVersion(change.Version)
+				So(err, ShouldBeNil)
+			}
+			jobs := updates.Items[0].GetBeforeUpdateJobs()
+			So(jobs, ShouldHaveLength, 1)
+			So(jobs[0].GenerateName, ShouldEqual, "web-migrate-")
+			So(jobs[0].Spec.Template.Spec.Containers[0].Image, ShouldEqual, "registry.example.com/app:1.1.0")
+			So(jobs[0].Spec.Template.Spec.Containers[1].Image, ShouldEqual, "registry.example.com/worker:1.2.0")
+			// The hook job is a template, it is not changed
+			So(hook.Spec.Template.Spec.Containers[0].Image, ShouldEqual, "registry.example.com/app:1.0.0")
+
+			aContainers[1].beforeUpdate = other
+			So(updates.Items[0].GetBeforeUpdateJobs(), ShouldHaveLength, 2)
+		})
+	})
+}
+
+func TestTemplatePatch(t *testing.T) {
+	Convey("Test pod template patch", t, func() {
+		d := &ext.Deployment{}
+		d.Spec.Template.Spec.InitContainers = []api.Container{
+			{Name: "migrate", Image: "registry.example.com/app:1.0.0"},
+		}
+		d.Spec.Template.Spec.Containers = []api.Container{
+			{Name: "web", Image: "registry.example.com/app:1.0.0"},
+			{Name: "worker", Image: "registry.example.com/worker:1.0.0"},
+		}
+		w := DeploymentWorkload{d}
+		web := &Container{container: d.Spec.Template.Spec.Containers[0], workload: w}
+		before := copyTemplate(w.GetTemplate())
+
+		Convey("Nothing changed", func() {
+			patch, initChanged := templatePatch(&before, w.GetTemplate())
+			So(patch, ShouldBeNil)
+			So(initChanged, ShouldBeFalse)
+		})
+
+		Convey("Only changed images and annotations are patched", func() {
+			_, err := web.SetImageVersion(registry.Version{Tag: "1.1.0"})
+			So(err, ShouldBeNil)
+			w.GetTemplate().SetAnnotations(map[string]string{"autoupdate_digest_web": "sha256:abc"})
+			patch, initChanged := templatePatch(&before, w.GetTemplate())
+			So(initChanged, ShouldBeFalse)
+			So(patch, ShouldResemble, map[string]interface{}{
+				"spec": map[string]interface{}{
+					"containers": []map[string]string{{"name": "web", "image": "registry.example.com/app:1.1.0"}},
+				},
+				"metadata": map[string]interface{}{
+					"annotations": map[string]string{"autoupdate_digest_web": "sha256:abc"},
+				},
+			})
+		})
+
+		Convey("Init container changes are reported", func() {
+			d.SetAnnotations(map[string]string{"autoupdate_lockstep_migrate": "web"})
+			_, err := web.SetImageVersion(registry.Version{Tag: "1.1.0"})
+			So(err, ShouldBeNil)
+			patch, initChanged := templatePatch(&before, w.GetTemplate())
+			So(patch, ShouldNotBeNil)
+			So(initChanged, ShouldBeTrue)
+		})
+
+		Convey("A change is stale if the container is at a newer version", func() {
+			v, err := registry.NewVersion("1.1.0")
+			So(err, ShouldBeNil)
+			change := Change{Container: web, Version: *v}
+			So(change.stale(), ShouldBeFalse)
+
+			d.Spec.Template.Spec.Containers[0].Image = "registry.example.com/app:1.2.0"
+			So(web.refresh(), ShouldBeTrue)
+			So(change.stale(), ShouldBeTrue)
+
+			d.Spec.Template.Spec.Containers = d.Spec.Template.Spec.Containers[1:]
+			So(web.refresh(), ShouldBeFalse)
+		})
+	})
+}
+
+func TestSetVersions(t *testing.T) {
+	Convey("Test annotations re-evaluated before the write", t, func() {
+		d := &ext.Deployment{}
+		d.Name = "app"
+		d.SetAnnotations(map[string]string{"autoupdate_version_range_web": "^1.0"})
+		d.Spec.Template.Spec.Containers = []api.Container{
+			{Name: "web", Image: "registry.example.com/app:1.0.0"},
+		}
+		w := DeploymentWorkload{d}
+		v, err := registry.NewVersion("1.1.0")
+		So(err, ShouldBeNil)
+		updates := new(UpdateList)
+		updates.Add(&Container{container: d.Spec.Template.Spec.Containers[0], workload: w}, *v)
+		u := updates.Items[0]
+
+		Convey("A version allowed by annotations is set", func() {
+			results, err := u.setVersions()
+			So(err, ShouldBeNil)
+			So(d.Spec.Template.Spec.Containers[0].Image, ShouldEqual, "registry.example.com/app:1.1.0")
+			So(results, ShouldHaveLength, 1)
+			So(results[0].Status, ShouldEqual, StatusUpdated)
+			So(results[0].Version, ShouldEqual, "1.1.0")
+		})
+
+		Convey("A container updated by someone else is up to date", func() {
+			d.Spec.Template.Spec.Containers[0].Image = "registry.example.com/app:1.2.0"
+			results, err := u.setVersions()
+			So(err, ShouldBeNil)
+			So(d.Spec.Template.Spec.Containers[0].Image, ShouldEqual, "registry.example.com/app:1.2.0")
+			So(results[0].Status, ShouldEqual, StatusUpToDate)
+		})
+
+		Convey("A version out of the changed range is not set", func() {
+			d.Annotations["autoupdate_version_range_web"] = "~1.0.0"
+			results, err := u.setVersions()
+			So(err, ShouldBeNil)
+			So(d.Spec.Template.Spec.Containers[0].Image, ShouldEqual, "registry.example.com/app:1.0.0")
+			So(results[0].Status, ShouldEqual, StatusSkipped)
+			So(results[0].Reason, ShouldNotBeEmpty)
+		})
+
+		Convey("A version is not set if updates are turned off", func() {
+			d.Annotations["autoupdate_policy_web"] = "none"
+			results, err := u.setVersions()
+			So(err, ShouldBeNil)
+			So(d.Spec.Template.Spec.Containers[0].Image, ShouldEqual, "registry.example.com/app:1.0.0")
+			So(results[0].Status, ShouldEqual, StatusAvailable)
+			So(results[0].Version, ShouldEqual, "1.1.0")
+		})
+
+		Convey("Invalid annotations fail the update", func() {
+			d.Annotations["autoupdate_version_range_web"] = "^1.a"
+			_, err := u.setVersions()
+			So(err, ShouldHaveSameTypeAs, &ConfigError{})
+			So(d.Spec.Template.Spec.Containers[0].Image, ShouldEqual, "registry.example.com/app:1.0.0")
+		})
+	})
+}
@@ -0,0 +1,162 @@
+package updater
+
+import (
+	"fmt"
+	"github.com/sabakaio/k8s-updater/pkg/registry"
+	"k8s.io/kubernetes/pkg/api"
+	"k8s.io/kubernetes/pkg/api/unversioned"
+	client "k8s.io/kubernetes/pkg/client/unversioned"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Prefixes of per-container annotations, the container name follows the prefix
+var containerAnnotationPrefixes = []string{
+	"before_autoupdate_",
+	"autoupdate_version_range_",
+	"autoupdate_version_scheme_",
+	"autoupdate_tag_pattern_",
+	"autoupdate_follow_digest_",
+	"autoupdate_track_",
+	"autoupdate_source_",
+	"autoupdate_channel_",
+	"autoupdate_prefer_final_",
+	"autoupdate_ignore_build_metadata_",
+	"autoupdate_policy_",
+	lockstepAnnotationPrefix,
+}
+
+// Event reason of a workload skipped because of invalid annotations
+const invalidConfigReason = "InvalidAutoupdateConfig"
+
+// ConfigError is an invalid updater configuration of a workload. The workload is skipped.
+type ConfigError struct {
+	Workload Workload
+	Errors   []error
+}
+
+func (e *ConfigError) Error() string {
+	messages := make([]string, len(e.Errors))
+	for i, err := range e.Errors {
+		messages[i] = err.Error()
+	}
+	return fmt.Sprintf("%s has invalid autoupdate config: %s", WorkloadName(e.Workload), strings.Join(messages, "; "))
+}
+
+// RecordEvent records the error as a warning event of the workload
+func (e *ConfigError) RecordEvent(k *client.Client) error {
+	now := unversioned.Now()
+	event := &api.Event{
+		ObjectMeta: api.ObjectMeta{
+			GenerateName: e.Workload.GetName() + ".",
+			Namespace:    e.Workload.GetNamespace(),
+		},
+		InvolvedObject: workloadReference(e.Workload),
+		Reason:         invalidConfigReason,
+		Message:        e.Error(),
+		Source:         api.EventSource{Component: "k8s-updater"},
+		FirstTimestamp: now,
+		LastTimestamp:  now,
+		Count:          1,
+		Type:           api.EventTypeWarning,
+	}
+	_, err := k.Events(e.Workload.GetNamespace()).Create(event)
+	return err
+}
+
+// validateWorkload checks updater annotations of the workload:
+// container names, boolean values, ranges, schemes, tag patterns, channels, policies, sources and lockstep containers.
+// Hook jobs are checked while listing containers.
+func validateWorkload(w Workload) (errs []error) {
+	annotations := w.GetAnnotations()
+	spec := w.GetTemplate().Spec
+	containers := make(map[string]bool)
+	for _, c := range allContainers(spec) {
+		containers[c.Name] = true
+	}
+
+	// Check annotations in order to report errors in the same order every run
+	keys := make([]string, 0, len(annotations))
+	for key := range annotations {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if name, ok := annotationContainer(key); ok && !containers[name] {
+			errs = append(errs, fmt.Errorf("annotation %s: there is no container '%s'", key, name))
+		}
+	}
+	if err := validateBool(annotations, "autoupdate_pin_digest"); err != nil {
+		errs = append(errs, err)
+	}
+
+	for i, c := range allContainers(spec) {
+		container := &Container{container: c, workload: w, init: i < len(spec.InitContainers)}
+		errs = append(errs, container.validate()...)
+	}
+	return
+}
+
+// validate checks per-container annotations
+func (c *Container) validate() (errs []error) {
+	annotations := c.workload.GetAnnotations()
+	name := c.GetName()
+	for _, prefix := range []string{"autoupdate_follow_digest_", "autoupdate_prefer_final_", "autoupdate_ignore_build_metadata_"} {
+		if err := validateBool(annotations, prefix+name); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if c.FollowDigest() && c.TrackTag() != "" {
+		errs = append(errs, fmt.Errorf("container '%s' could not both follow its tag and track '%s'", name, c.TrackTag()))
+	}
+
+	// The scheme error includes an invalid tag pattern
+	if _, err := c.GetVersionScheme(); err != nil {
+		errs = append(errs, fmt.Errorf("container '%s': %s", name, err))
+	} else if _, err := c.GetVersionFilter(); err != nil {
+		errs = append(errs, err)
+	}
+	if _, err := registry.ChannelRange(c.GetReleaseChannel()); err != nil {
+		errs = append(errs, fmt.Errorf("container '%s': %s", name, err))
+	}
+	if err := registry.ValidatePolicy(c.GetUpdatePolicy()); err != nil {
+		errs = append(errs, fmt.Errorf("container '%s': %s", name, err))
+	}
+	if _, err := registry.GetSource(annotations["autoupdate_source_"+name]); err != nil {
+		errs = append(errs, fmt.Errorf("container '%s': %s", name, err))
+	}
+	if err := c.validateLockstep(); err != nil {
+		errs = append(errs, err)
+	}
+	if hook, ok := annotations["before_autoupdate_"+name]; ok && hook == "" {
+		errs = append(errs, fmt.Errorf("container '%s': empty before update hook name", name))
+	}
+	return
+}
+
+// annotationContainer returns the container name of a per-container annotation.
+// The longest prefix wins, so `autoupdate_version_range_web` is not a container `range_web`.
+func annotationContainer(key string) (name string, ok bool) {
+	prefix := ""
+	for _, p := range containerAnnotationPrefixes {
+		if strings.HasPrefix(key, p) && len(p) > len(prefix) {
+			prefix = p
+		}
+	}
+	if prefix == "" {
+		return
+	}
+	return key[len(prefix):], true
+}
+
+func validateBool(annotations map[string]string, key string) error {
+	value, ok := annotations[key]
+	if !ok {
+		return nil
+	}
+	if _, err := strconv.ParseBool(value); err != nil {
+		return fmt.Errorf("annotation %s: '%s' is not a boolean", key, value)
+	}
+	return nil
+}
@@ -0,0 +1,110 @@
+package updater
+
+import (
+	. "github.com/smartystreets/goconvey/convey"
+	"k8s.io/kubernetes/pkg/api"
+	ext "k8s.io/kubernetes/pkg/apis/extensions"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	newDeployment := func(annotations map[string]string) Workload {
+		d := &ext.Deployment{}
+		d.Name = "my-deployment"
+		d.SetAnnotations(annotations)
+		d.Spec.Template.Spec.InitContainers = []api.Container{
+			{Name: "migrate", Image: "my-image:1.0.0"},
+		}
+		d.Spec.Template.Spec.Containers = []api.Container{
+			{Name: "web", Image: "my-image:1.0.0"},
+			{Name: "worker", Image: "my-worker:1.0.0"},
+		}
+		return DeploymentWorkload{d}
+	}
+
+	Convey("Test workload annotations validation", t, func() {
+		Convey("Valid annotations", func() {
+			d := newDeployment(map[string]string{
+				"autoupdate_version_range_web":  "^1.0.0",
+				"autoupdate_policy_web":         "minor",
+				"autoupdate_channel_worker":     "beta",
+				"autoupdate_follow_digest_web":  "false",
+				"autoupdate_pin_digest":         "true",
+				"before_autoupdate_worker":      "migrate",
+				"autoupdate_version_scheme_web": "semver",
+			})
+			So(validateWorkload(d), ShouldBeEmpty)
+		})
+
+		Convey("Invalid version range is an error", func() {
+			d := newDeployment(map[string]string{"autoupdate_version_range_web": "^1.0.0 <2.0.0.0"})
+			errs := validateWorkload(d)
+			So(errs, ShouldHaveLength, 1)
+			So(errs[0].Error(), ShouldContainSubstring, "autoupdate_version_range_web")
+		})
+
+		Convey("Unknown container is an error", func() {
+			d := newDeployment(map[string]string{
+				"autoupdate_version_range_wbe": "^1.0.0",
+				"before_autoupdate_db":         "migrate",
+			})
+			errs := validateWorkload(d)
+			So(errs, ShouldHaveLength, 2)
+			So(errs[0].Error(), ShouldContainSubstring, "no container 'wbe'")
+			So(errs[1].Error(), ShouldContainSubstring, "no container 'db'")
+		})
+
+		Convey("Invalid values are errors", func() {
+			d := newDeployment(map[string]string{
+				"autoupdate_policy_web":       "latest",
+				"autoupdate_channel_web":      "nightly",
+				"autoupdate_pin_digest":       "yes",
+				"autoupdate_prefer_final_web": "no",
+				"autoupdate_tag_pattern_web":  `^\d+$`,
+				"before_autoupdate_worker":    "",
+			})
+			So(validateWorkload(d), ShouldHaveLength, 6)
+		})
+
+		Convey("Following a tag and tracking another one is an error", func() {
+			d := newDeployment(map[string]string{
+				"autoupdate_follow_digest_web": "true",
+				"autoupdate_track_web":         "stable",
+			})
+			So(validateWorkload(d), ShouldHaveLength, 1)
+		})
+
+		Convey("Init containers take the same annotations and could be in lockstep", func() {
+			d := newDeployment(map[string]string{
+				"autoupdate_version_range_migrate": "^1.0.0",
+				"autoupdate_lockstep_migrate":      "web",
+			})
+			So(validateWorkload(d), ShouldBeEmpty)
+
+			d = newDeployment(map[string]string{
+				"autoupdate_lockstep_migrate": "db",
+				"autoupdate_lockstep_web":     "worker",
+			})
+			errs := validateWorkload(d)
+			So(errs, ShouldHaveLength, 2)
+			So(errs[0].Error(), ShouldContainSubstring, "'db'")
+			So(errs[1].Error(), ShouldContainSubstring, "not an init container")
+
+			d = newDeployment(map[string]string{"autoupdate_lockstep_migrate": "worker"})
+			errs = validateWorkload(d)
+			So(errs, ShouldHaveLength, 1)
+			So(errs[0].Error(), ShouldContainSubstring, "same image")
+		})
+
+		Convey("Config error lists all errors", func() {
+			d := newDeployment(map[string]string{
+				"autoupdate_policy_web":  "latest",
+				"autoupdate_channel_web": "nightly",
+			})
+			err := &ConfigError{Workload: d, Errors: validateWorkload(d)}
+			So(err.Error(), ShouldStartWith, "deployment/my-deployment has invalid autoupdate config: ")
+			So(err.Error(), ShouldContainSubstring, "latest")
+			So(err.Error(), ShouldContainSubstring, "nightly")
+		})
+	})
+}
@@ -0,0 +1,497 @@
+package updater
+
+import (
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"github.com/sabakaio/k8s-updater/pkg/util"
+	"k8s.io/kubernetes/pkg/api"
+	"k8s.io/kubernetes/pkg/api/errors"
+	"k8s.io/kubernetes/pkg/apis/apps"
+	"k8s.io/kubernetes/pkg/apis/batch"
+	ext "k8s.io/kubernetes/pkg/apis/extensions"
+	client "k8s.io/kubernetes/pkg/client/unversioned"
+	"k8s.io/kubernetes/pkg/types"
+	"strings"
+)
+
+// Workload kinds to list, chosen with `--kinds`
+const (
+	KindDeployment            = "deployment"
+	KindDaemonSet             = "daemonset"
+	KindReplicaSet            = "replicaset"
+	KindReplicationController = "replicationcontroller"
+	KindJob                   = "job"
+	KindPetSet                = "petset"
+)
+
+// DefaultKinds are workload kinds to list. Other kinds are opt-in with `--kinds`, they need more permissions,
+// Jobs are re-created and PetSets are alpha and disabled on most clusters.
+var DefaultKinds = []string{KindDeployment}
+
+// Annotation of ReplicaSets managed by a Deployment, they are updated through the Deployment
+const deploymentRevisionAnnotation = "deployment.kubernetes.io/revision"
+
+// Workload is a Kubernetes object running containers of a pod template, e.g. a Deployment or a DaemonSet
+type Workload interface {
+	// GetKind returns the object kind, e.g. `Deployment`
+	GetKind() string
+	// GetAPIVersion returns the API group version of the kind, e.g. `extensions/v1beta1`
+	GetAPIVersion() string
+	GetName() string
+	GetNamespace() string
+	GetUID() types.UID
+	GetResourceVersion() string
+	GetAnnotations() map[string]string
+	// GetTemplate returns the pod template. Changes of the template are saved with `Update`.
+	GetTemplate() *api.PodTemplateSpec
+	// Update saves the workload on the cluster
+	Update(k *client.Client) error
+	// Reload re-fetches the workload from the cluster
+	Reload(k *client.Client) error
+	// Patch changes the workload on the cluster with a strategic merge patch
+	Patch(k *client.Client, data []byte) error
+}
+
+// workloadLister lists workloads of a kind
+type workloadLister func(k *client.Client, namespace string, opts api.ListOptions) ([]Workload, error)
+
+var workloadListers = map[string]workloadLister{
+	KindDeployment:            listDeployments,
+	KindDaemonSet:             listDaemonSets,
+	KindReplicaSet:            listReplicaSets,
+	KindReplicationController: listReplicationControllers,
+	KindJob:                   listJobs,
+	KindPetSet:                listPetSets,
+}
+
+// ValidateKinds returns an error if a workload kind is not supported
+func ValidateKinds(kinds []string) error {
+	for _, kind := range kinds {
+		if _, ok := workloadListers[kind]; !ok {
+			return fmt.Errorf("unknown workload kind '%s'", kind)
+		}
+	}
+	return nil
+}
+
+// ListWorkloads lists workloads of the kinds in the namespace
+func ListWorkloads(k *client.Client, namespace string, kinds []string, opts api.ListOptions) (workloads []Workload, err error) {
+	if err = ValidateKinds(kinds); err != nil {
+		return
+	}
+	for _, kind := range kinds {
+		items, e := workloadListers[kind](k, namespace, opts)
+		if e != nil {
+			err = fmt.Errorf("cannot list %ss: %s", kind, e)
+			return
+		}
+		workloads = append(workloads, items...)
+	}
+	return
+}
+
+// WorkloadName returns the workload kind and name, e.g. `deployment/web`
+func WorkloadName(w Workload) string {
+	return strings.ToLower(w.GetKind()) + "/" + w.GetName()
+}
+
+// allContainers returns init containers and containers of the pod spec
+func allContainers(spec api.PodSpec) []api.Container {
+	containers := make([]api.Container, 0, len(spec.InitContainers)+len(spec.Containers))
+	return append(append(containers, spec.InitContainers...), spec.Containers...)
+}
+
+// workloadReference returns a reference to the workload, e.g. for events
+func workloadReference(w Workload) api.ObjectReference {
+	return api.ObjectReference{
+		Kind:            w.GetKind(),
+		APIVersion:      w.GetAPIVersion(),
+		Namespace:       w.GetNamespace(),
+		Name:            w.GetName(),
+		UID:             w.GetUID(),
+		ResourceVersion: w.GetResourceVersion(),
+	}
+}
+
+// DeploymentWorkload is a Deployment, its pods are replaced with a rolling update
+type DeploymentWorkload struct{ *ext.Deployment }
+
+// GetKind implements Workload
+func (DeploymentWorkload) GetKind() string { return "Deployment" }
+
+// GetAPIVersion implements Workload
+func (DeploymentWorkload) GetAPIVersion() string { return "extensions/v1beta1" }
+
+// GetTemplate implements Workload
+func (w DeploymentWorkload) GetTemplate() *api.PodTemplateSpec { return &w.Spec.Template }
+
+// Update implements Workload
+func (w DeploymentWorkload) Update(k *client.Client) error {
+	d, err := k.Deployments(w.Namespace).Update(w.Deployment)
+	if err == nil {
+		*w.Deployment = *d
+	}
+	return err
+}
+
+// Reload implements Workload
+func (w DeploymentWorkload) Reload(k *client.Client) error {
+	d, err := k.Deployments(w.Namespace).Get(w.Name)
+	if err == nil {
+		*w.Deployment = *d
+	}
+	return err
+}
+
+// Patch implements Workload
+func (w DeploymentWorkload) Patch(k *client.Client, data []byte) error {
+	d := &ext.Deployment{}
+	err := k.Extensions().Patch(api.StrategicMergePatchType).
+		Namespace(w.Namespace).Resource("deployments").Name(w.Name).Body(data).Do().Into(d)
+	if err == nil {
+		*w.Deployment = *d
+	}
+	return err
+}
+
+func listDeployments(k *client.Client, namespace string, opts api.ListOptions) (workloads []Workload, err error) {
+	list, err := k.Deployments(namespace).List(opts)
+	if err != nil {
+		return
+	}
+	for i := range list.Items {
+		workloads = append(workloads, DeploymentWorkload{&list.Items[i]})
+	}
+	return
+}
+
+// DaemonSetWorkload is a DaemonSet. There is no rolling update of DaemonSets in Kubernetes 1.3, new pods use the new template.
+type DaemonSetWorkload struct{ *ext.DaemonSet }
+
+// GetKind implements Workload
+func (DaemonSetWorkload) GetKind() string { return "DaemonSet" }
+
+// GetAPIVersion implements Workload
+func (DaemonSetWorkload) GetAPIVersion() string { return "extensions/v1beta1" }
+
+// GetTemplate implements Workload
+func (w DaemonSetWorkload) GetTemplate() *api.PodTemplateSpec { return &w.Spec.Template }
+
+// Update implements Workload
+func (w DaemonSetWorkload) Update(k *client.Client) error {
+	d, err := k.Extensions().DaemonSets(w.Namespace).Update(w.DaemonSet)
+	if err == nil {
+		*w.DaemonSet = *d
+	}
+	return err
+}
+
+// Reload implements Workload
+func (w DaemonSetWorkload) Reload(k *client.Client) error {
+	d, err := k.Extensions().DaemonSets(w.Namespace).Get(w.Name)
+	if err == nil {
+		*w.DaemonSet = *d
+	}
+	return err
+}
+
+// Patch implements Workload
+func (w DaemonSetWorkload) Patch(k *client.Client, data []byte) error {
+	d := &ext.DaemonSet{}
+	err := k.Extensions().Patch(api.StrategicMergePatchType).
+		Namespace(w.Namespace).Resource("daemonsets").Name(w.Name).Body(data).Do().Into(d)
+	if err == nil {
+		*w.DaemonSet = *d
+	}
+	return err
+}
+
+func listDaemonSets(k *client.Client, namespace string, opts api.ListOptions) (workloads []Workload, err error) {
+	list, err := k.Extensions().DaemonSets(namespace).List(opts)
+	if err != nil {
+		return
+	}
+	for i := range list.Items {
+		workloads = append(workloads, DaemonSetWorkload{&list.Items[i]})
+	}
+	return
+}
+
+// ReplicaSetWorkload is a ReplicaSet not managed by a Deployment. Running pods are not replaced, new pods use the new template.
+type ReplicaSetWorkload struct{ *ext.ReplicaSet }
+
+// GetKind implements Workload
+func (ReplicaSetWorkload) GetKind() string { return "ReplicaSet" }
+
+// GetAPIVersion implements Workload
+func (ReplicaSetWorkload) GetAPIVersion() string { return "extensions/v1beta1" }
+
+// GetTemplate implements Workload
+func (w ReplicaSetWorkload) GetTemplate() *api.PodTemplateSpec { return &w.Spec.Template }
+
+// Update implements Workload
+func (w ReplicaSetWorkload) Update(k *client.Client) error {
+	rs, err := k.Extensions().ReplicaSets(w.Namespace).Update(w.ReplicaSet)
+	if err == nil {
+		*w.ReplicaSet = *rs
+	}
+	return err
+}
+
+// Reload implements Workload
+func (w ReplicaSetWorkload) Reload(k *client.Client) error {
+	rs, err := k.Extensions().ReplicaSets(w.Namespace).Get(w.Name)
+	if err == nil {
+		*w.ReplicaSet = *rs
+	}
+	return err
+}
+
+// Patch implements Workload
+func (w ReplicaSetWorkload) Patch(k *client.Client, data []byte) error {
+	rs := &ext.ReplicaSet{}
+	err := k.Extensions().Patch(api.StrategicMergePatchType).
+		Namespace(w.Namespace).Resource("replicasets").Name(w.Name).Body(data).Do().Into(rs)
+	if err == nil {
+		*w.ReplicaSet = *rs
+	}
+	return err
+}
+
+func listReplicaSets(k *client.Client, namespace string, opts api.ListOptions) (workloads []Workload, err error) {
+	list, err := k.Extensions().ReplicaSets(namespace).List(opts)
+	if err != nil {
+		return
+	}
+	for i := range list.Items {
+		// ReplicaSets of a Deployment copy its pod template labels, they are updated with the Deployment
+		if _, ok := list.Items[i].GetAnnotations()[deploymentRevisionAnnotation]; ok {
+			continue
+		}
+		workloads = append(workloads, ReplicaSetWorkload{&list.Items[i]})
+	}
+	return
+}
+
+// ReplicationControllerWorkload is a ReplicationController. Running pods are not replaced, new pods use the new template.
+type ReplicationControllerWorkload struct{ *api.ReplicationController }
+
+// GetKind implements Workload
+func (ReplicationControllerWorkload) GetKind() string { return "ReplicationController" }
+
+// GetAPIVersion implements Workload
+func (ReplicationControllerWorkload) GetAPIVersion() string { return "v1" }
+
+// GetTemplate implements Workload
+func (w ReplicationControllerWorkload) GetTemplate() *api.PodTemplateSpec {
+	if w.Spec.Template == nil {
+		w.Spec.Template = &api.PodTemplateSpec{}
+	}
+	return w.Spec.Template
+}
+
+// Update implements Workload
+func (w ReplicationControllerWorkload) Update(k *client.Client) error {
+	rc, err := k.ReplicationControllers(w.Namespace).Update(w.ReplicationController)
+	if err == nil {
+		*w.ReplicationController = *rc
+	}
+	return err
+}
+
+// Reload implements Workload
+func (w ReplicationControllerWorkload) Reload(k *client.Client) error {
+	rc, err := k.ReplicationControllers(w.Namespace).Get(w.Name)
+	if err == nil {
+		*w.ReplicationController = *rc
+	}
+	return err
+}
+
+// Patch implements Workload
+func (w ReplicationControllerWorkload) Patch(k *client.Client, data []byte) error {
+	rc := &api.ReplicationController{}
+	err := k.RESTClient.Patch(api.StrategicMergePatchType).
+		Namespace(w.Namespace).Resource("replicationcontrollers").Name(w.Name).Body(data).Do().Into(rc)
+	if err == nil {
+		*w.ReplicationController = *rc
+	}
+	return err
+}
+
+func listReplicationControllers(k *client.Client, namespace string, opts api.ListOptions) (workloads []Workload, err error) {
+	list, err := k.ReplicationControllers(namespace).List(opts)
+	if err != nil {
+		return
+	}
+	for i := range list.Items {
+		workloads = append(workloads, ReplicationControllerWorkload{&list.Items[i]})
+	}
+	return
+}
+
+// JobWorkload is a Job used as a template, e.g. of a before update hook.
+// The pod template of a Job is immutable, so a finished Job is re-created and runs again.
+type JobWorkload struct{ *batch.Job }
+
+// GetKind implements Workload
+func (JobWorkload) GetKind() string { return "Job" }
+
+// GetAPIVersion implements Workload
+func (JobWorkload) GetAPIVersion() string { return "batch/v1" }
+
+// GetTemplate implements Workload
+func (w JobWorkload) GetTemplate() *api.PodTemplateSpec { return &w.Spec.Template }
+
+// Update implements Workload. A finished Job is deleted and created with the same name, then pods of the old Job
+// are deleted. A running Job is not replaced. A Job changed since it was loaded is a conflict. If the new Job
+// could not be created, the old one is created back with zero parallelism, so it is kept but does not run again.
+func (w JobWorkload) Update(k *client.Client) error {
+	if !jobFinished(w.Job) {
+		return fmt.Errorf("job '%s' is running, it is replaced only when finished", w.Name)
+	}
+	jobs := k.Batch().Jobs(w.Namespace)
+	old, err := jobs.Get(w.Name)
+	if err != nil {
+		return err
+	}
+	if old.UID != w.UID || old.ResourceVersion != w.ResourceVersion {
+		return errors.NewConflict(batch.Resource("jobs"), w.Name, fmt.Errorf("the job has been changed"))
+	}
+	job := newJob(w.Job)
+
+	uid := old.UID
+	if err := jobs.Delete(w.Name, &api.DeleteOptions{Preconditions: &api.Preconditions{UID: &uid}}); err != nil {
+		return err
+	}
+	created, err := jobs.Create(job)
+	if err != nil {
+		restored := newJob(old)
+		paused := int32(0)
+		restored.Spec.Parallelism = &paused
+		if _, e := jobs.Create(restored); e != nil {
+			return fmt.Errorf("could not create job '%s': %s, the old job could not be restored: %s", w.Name, err, e)
+		}
+		return fmt.Errorf("could not create job '%s', the old job is restored with zero parallelism: %s", w.Name, err)
+	}
+	*w.Job = *created
+	// Pods of the deleted Job have no controller to re-create them
+	if err := util.DeletePodsInJob(k, old); err != nil {
+		log.Errorf("workload=%s could not delete pods of the replaced job: %s", WorkloadName(w), err)
+	}
+	return nil
+}
+
+// jobFinished checks if the Job is complete or failed
+func jobFinished(job *batch.Job) bool {
+	for _, c := range job.Status.Conditions {
+		if (c.Type == batch.JobComplete || c.Type == batch.JobFailed) && c.Status == api.ConditionTrue {
+			return true
+		}
+	}
+	return false
+}
+
+// newJob returns a Job to create with the name, metadata and spec of the job
+func newJob(w *batch.Job) *batch.Job {
+	job := &batch.Job{}
+	job.Name = w.Name
+	job.Namespace = w.Namespace
+	job.Labels = w.Labels
+	job.Annotations = w.Annotations
+	job.Spec = w.Spec
+	// A generated selector matches the UID of the deleted Job, the new one gets its own
+	if w.Spec.ManualSelector == nil || !*w.Spec.ManualSelector {
+		job.Spec.Selector = nil
+		labels := make(map[string]string)
+		for key, value := range w.Spec.Template.Labels {
+			if key != "controller-uid" && key != "job-name" {
+				labels[key] = value
+			}
+		}
+		job.Spec.Template.Labels = labels
+	}
+	return job
+}
+
+// Reload implements Workload
+func (w JobWorkload) Reload(k *client.Client) error {
+	job, err := k.Batch().Jobs(w.Namespace).Get(w.Name)
+	if err == nil {
+		*w.Job = *job
+	}
+	return err
+}
+
+// Patch implements Workload. The pod template of a Job could not be patched, the Job is re-created with `Update`.
+func (w JobWorkload) Patch(k *client.Client, data []byte) error {
+	return w.Update(k)
+}
+
+func listJobs(k *client.Client, namespace string, opts api.ListOptions) (workloads []Workload, err error) {
+	list, err := k.Batch().Jobs(namespace).List(opts)
+	if err != nil {
+		return
+	}
+	for i := range list.Items {
+		// A running Job is not replaced, e.g. the updater itself, it is checked when finished
+		if !jobFinished(&list.Items[i]) {
+			log.Debugf("workload=job/%s is running, skipped", list.Items[i].Name)
+			continue
+		}
+		workloads = append(workloads, JobWorkload{&list.Items[i]})
+	}
+	return
+}
+
+// PetSetWorkload is a PetSet. Running pods are not replaced, pods use the new template when they are re-created.
+type PetSetWorkload struct{ *apps.PetSet }
+
+// GetKind implements Workload
+func (PetSetWorkload) GetKind() string { return "PetSet" }
+
+// GetAPIVersion implements Workload
+func (PetSetWorkload) GetAPIVersion() string { return "apps/v1alpha1" }
+
+// GetTemplate implements Workload
+func (w PetSetWorkload) GetTemplate() *api.PodTemplateSpec { return &w.Spec.Template }
+
+// Update implements Workload
+func (w PetSetWorkload) Update(k *client.Client) error {
+	ps, err := k.Apps().PetSets(w.Namespace).Update(w.PetSet)
+	if err == nil {
+		*w.PetSet = *ps
+	}
+	return err
+}
+
+// Reload implements Workload
+func (w PetSetWorkload) Reload(k *client.Client) error {
+	ps, err := k.Apps().PetSets(w.Namespace).Get(w.Name)
+	if err == nil {
+		*w.PetSet = *ps
+	}
+	return err
+}
+
+// Patch implements Workload
+func (w PetSetWorkload) Patch(k *client.Client, data []byte) error {
+	ps := &apps.PetSet{}
+	err := k.Apps().Patch(api.StrategicMergePatchType).
+		Namespace(w.Namespace).Resource("petsets").Name(w.Name).Body(data).Do().Into(ps)
+	if err == nil {
+		*w.PetSet = *ps
+	}
+	return err
+}
+
+func listPetSets(k *client.Client, namespace string, opts api.ListOptions) (workloads []Workload, err error) {
+	list, err := k.Apps().PetSets(namespace).List(opts)
+	if err != nil {
+		return
+	}
+	for i := range list.Items {
+		workloads = append(workloads, PetSetWorkload{&list.Items[i]})
+	}
+	return
+}
@@ -0,0 +1,90 @@
+package updater
+
+import (
+	"github.com/sabakaio/k8s-updater/pkg/registry"
+	. "github.com/smartystreets/goconvey/convey"
+	"k8s.io/kubernetes/pkg/api"
+	"k8s.io/kubernetes/pkg/api/unversioned"
+	"k8s.io/kubernetes/pkg/apis/apps"
+	"k8s.io/kubernetes/pkg/apis/batch"
+	ext "k8s.io/kubernetes/pkg/apis/extensions"
+	"testing"
+)
+
+func TestWorkload(t *testing.T) {
+	Convey("Test workloads", t, func() {
+		Convey("Kinds are validated", func() {
+			// Other kinds need more permissions than updating Deployments, they are opt-in
+			So(DefaultKinds, ShouldResemble, []string{KindDeployment})
+			So(ValidateKinds(DefaultKinds), ShouldBeNil)
+			So(ValidateKinds([]string{KindPetSet}), ShouldBeNil)
+			So(ValidateKinds([]string{"statefulset"}), ShouldNotBeNil)
+		})
+
+		Convey("Workloads are referenced by kind", func() {
+			ds := &ext.DaemonSet{}
+			ds.Name = "agent"
+			ds.Namespace = "kube-system"
+			So(WorkloadName(DaemonSetWorkload{ds}), ShouldEqual, "daemonset/agent")
+			ref := workloadReference(DaemonSetWorkload{ds})
+			So(ref.Kind, ShouldEqual, "DaemonSet")
+			So(ref.APIVersion, ShouldEqual, "extensions/v1beta1")
+			So(ref.Namespace, ShouldEqual, "kube-system")
+
+			ps := &apps.PetSet{}
+			ps.Name = "db"
+			So(WorkloadName(PetSetWorkload{ps}), ShouldEqual, "petset/db")
+		})
+
+		Convey("ReplicationController without a template gets an empty one", func() {
+			rc := &api.ReplicationController{}
+			template := ReplicationControllerWorkload{rc}.GetTemplate()
+			So(template, ShouldNotBeNil)
+			So(rc.Spec.Template, ShouldEqual, template)
+		})
+
+		Convey("Only finished Jobs are replaced", func() {
+			job := &batch.Job{}
+			So(jobFinished(job), ShouldBeFalse)
+			job.Status.Conditions = []batch.JobCondition{{Type: batch.JobComplete, Status: api.ConditionTrue}}
+			So(jobFinished(job), ShouldBeTrue)
+			job.Status.Conditions = []batch.JobCondition{{Type: batch.JobFailed, Status: api.ConditionTrue}}
+			So(jobFinished(job), ShouldBeTrue)
+		})
+
+		Convey("A replacement Job gets its own generated selector", func() {
+			job := &batch.Job{}
+			job.Name = "render"
+			job.UID = "1234"
+			job.ResourceVersion = "42"
+			job.Spec.Selector = &unversioned.LabelSelector{MatchLabels: map[string]string{"controller-uid": "1234"}}
+			job.Spec.Template.Labels = map[string]string{"controller-uid": "1234", "job-name": "render", "app": "render"}
+			replacement := newJob(job)
+			So(replacement.Name, ShouldEqual, "render")
+			So(replacement.UID, ShouldBeEmpty)
+			So(replacement.ResourceVersion, ShouldBeEmpty)
+			So(replacement.Spec.Selector, ShouldBeNil)
+			So(replacement.Spec.Template.Labels, ShouldResemble, map[string]string{"app": "render"})
+			// The old Job is not changed, it is restored if the replacement could not be created
+			So(job.Spec.Template.Labels, ShouldHaveLength, 3)
+		})
+
+		Convey("Containers of a workload change the same pod template", func() {
+			rs := &ext.ReplicaSet{}
+			rs.Spec.Template.Spec.Containers = []api.Container{
+				{Name: "web", Image: "web:1.0.0"},
+				{Name: "worker", Image: "worker:1.0.0"},
+			}
+			w := ReplicaSetWorkload{rs}
+			web := &Container{container: rs.Spec.Template.Spec.Containers[0], workload: w}
+			worker := &Container{container: rs.Spec.Template.Spec.Containers[1], workload: w}
+
+			_, err := web.SetImageVersion(registry.Version{Tag: "1.1.0"})
+			So(err, ShouldBeNil)
+			_, err = worker.SetImageVersion(registry.Version{Tag: "2.0.0"})
+			So(err, ShouldBeNil)
+			So(rs.Spec.Template.Spec.Containers[0].Image, ShouldEqual, "web:1.1.0")
+			So(rs.Spec.Template.Spec.Containers[1].Image, ShouldEqual, "worker:2.0.0")
+		})
+	})
+}